@@ -0,0 +1,123 @@
+package hlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CallRecorder logs one outbound call (mysql, redis, http, thrift...). It is started right
+// before the call, which records LogBegin for proc_time, and finished with the call's error,
+// which picks the success tag or its registered failure counterpart, see RegisterTag.
+//
+//	rec := logger.StartMysql(ctx, logrus.Fields{"sql": query})
+//	rows, err := db.QueryContext(ctx, query)
+//	rec.Finish(err)
+type CallRecorder struct {
+	logger *Logger
+	ctx    context.Context
+	tag    string
+	fields logrus.Fields
+}
+
+// StartCall starts recording a call logged with the success tag tag. A tag that is not registered
+// yet is registered with a failure tag derived from it, e.g. _com_foo_success and _com_foo_failure,
+// so a failed call is never logged under its success tag.
+func (l *Logger) StartCall(ctx context.Context, tag string, fields ...logrus.Fields) *CallRecorder {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, exist := LookupTag(tag); !exist && len(tag) > 0 {
+		failure := derivedFailureTag(tag)
+		if _, taken := LookupTag(failure); !taken {
+			fmt.Printf("call tag %s is not registered, register it with failure tag %s\n", tag, failure)
+			RegisterTagPair(tag, failure)
+		}
+	}
+	return &CallRecorder{logger: l, ctx: ctx, tag: tag, fields: GetLogField(tag, fields...)}
+}
+
+func (l *Logger) StartMysql(ctx context.Context, fields ...logrus.Fields) *CallRecorder {
+	return l.StartCall(ctx, LogTagMysqlOk, fields...)
+}
+
+func (l *Logger) StartRedis(ctx context.Context, fields ...logrus.Fields) *CallRecorder {
+	return l.StartCall(ctx, LogTagRedisOk, fields...)
+}
+
+func (l *Logger) StartHttp(ctx context.Context, fields ...logrus.Fields) *CallRecorder {
+	return l.StartCall(ctx, LogTagRequestOk, fields...)
+}
+
+func (l *Logger) StartThrift(ctx context.Context, fields ...logrus.Fields) *CallRecorder {
+	return l.StartCall(ctx, LogTagThriftOk, fields...)
+}
+
+func (l *Logger) StartRpc(ctx context.Context, fields ...logrus.Fields) *CallRecorder {
+	return l.StartCall(ctx, LogTagRpcOk, fields...)
+}
+
+// AddFields adds fields to the log written by Finish
+func (r *CallRecorder) AddFields(fields logrus.Fields) *CallRecorder {
+	for k, v := range fields {
+		r.fields[k] = v
+	}
+	return r
+}
+
+// Finish logs the call, as a failure with the error's code under LogCodeName when err is not nil
+func (r *CallRecorder) Finish(err error) {
+	if err == nil {
+		r.finish(true, nil, nil)
+		return
+	}
+	code, _ := ErrorCode(err)
+	r.finish(false, code, err)
+}
+
+// FinishWithCode logs the call with code under LogCodeName, as a failure when err is not nil
+func (r *CallRecorder) FinishWithCode(code interface{}, err error) {
+	r.finish(err == nil, code, err)
+}
+
+func (r *CallRecorder) finish(ok bool, code interface{}, err error) {
+	tag, level := r.tag, logrus.InfoLevel
+	if info, exist := LookupTag(r.tag); exist {
+		level = info.SuccessLevel
+		if !ok {
+			tag, level = info.Failure, info.FailureLevel
+		}
+	} else if !ok {
+		tag, level = derivedFailureTag(r.tag), logrus.ErrorLevel
+	}
+	if !ok && err != nil {
+		r.fields["errmsg"] = err.Error()
+	}
+	r.fields[LogTag] = tag
+	if code != nil {
+		r.fields[LogCodeName] = code
+	}
+	r.logger.WithContext(r.ctx).WithFields(r.fields).Log(level)
+}
+
+// ErrorCode returns the code of err, or of an error it wraps, implementing Code() with an
+// int, int32, int64, uint32 or string result
+func ErrorCode(err error) (interface{}, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		switch e := err.(type) {
+		case interface{ Code() int }:
+			return e.Code(), true
+		case interface{ Code() int32 }:
+			return e.Code(), true
+		case interface{ Code() int64 }:
+			return e.Code(), true
+		case interface{ Code() uint32 }:
+			return e.Code(), true
+		case interface{ Code() string }:
+			return e.Code(), true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,90 @@
+package hlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+type codeError struct{ code int }
+
+func (e codeError) Error() string { return fmt.Sprintf("code %d", e.code) }
+func (e codeError) Code() int     { return e.code }
+
+type stringCodeError struct{}
+
+func (stringCodeError) Error() string { return "not found" }
+func (stringCodeError) Code() string  { return "NOT_FOUND" }
+
+func TestCallRecorderTags(t *testing.T) {
+	if err := RegisterTag(TagInfo{Success: "_test_cache_hit", Failure: "_test_cache_miss",
+		SuccessLevel: logrus.DebugLevel, FailureLevel: logrus.WarnLevel}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name  string
+		tag   string
+		err   error
+		want  string
+		level string
+	}{
+		{"registered success", LogTagMysqlOk, nil, LogTagMysqlOk, "INFO"},
+		{"registered failure", LogTagMysqlOk, errors.New("timeout"), LogTagMysqlErr, "ERROR"},
+		{"levels from TagInfo on success", "_test_cache_hit", nil, "_test_cache_hit", "DEBUG"},
+		{"levels from TagInfo on failure", "_test_cache_hit", errors.New("miss"), "_test_cache_miss", "WARNING"},
+		{"unregistered success", "_test_foo_success", nil, "_test_foo_success", "INFO"},
+		{"unregistered failure gets a failure tag", "_test_bar_success", errors.New("down"), "_test_bar_failure", "ERROR"},
+		{"unregistered tag without suffix", "_test_baz", errors.New("down"), "_test_baz_failure", "ERROR"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			buf := &syncBuffer{}
+			newTestLogger(buf).StartCall(context.Background(), c.tag).Finish(c.err)
+			line := buf.jsonLines(t)[0]
+			if line[jsonKeyTag] != c.want || line[jsonKeyLevel] != c.level {
+				t.Errorf("logged %v at %v, want %s at %s", line[jsonKeyTag], line[jsonKeyLevel], c.want, c.level)
+			}
+			if c.err != nil && line["errmsg"] != c.err.Error() {
+				t.Errorf("errmsg %v, want %s", line["errmsg"], c.err)
+			}
+		})
+	}
+	if !IsFailureTag("_test_bar_failure") {
+		t.Error("the derived failure tag was not registered")
+	}
+}
+
+func TestCallRecorderCode(t *testing.T) {
+	buf := &syncBuffer{}
+	l := newTestLogger(buf)
+	l.StartRpc(context.Background(), logrus.Fields{"method": "Get"}).Finish(fmt.Errorf("call: %w", codeError{7}))
+	l.StartRpc(context.Background()).FinishWithCode(0, nil)
+	lines := buf.jsonLines(t)
+	if lines[0][LogCodeName] != float64(7) || lines[0]["method"] != "Get" || lines[0][jsonKeyTag] != LogTagRpcErr {
+		t.Errorf("unexpected failure log %v", lines[0])
+	}
+	if lines[1][LogCodeName] != float64(0) || lines[1][jsonKeyTag] != LogTagRpcOk {
+		t.Errorf("unexpected success log %v", lines[1])
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		code interface{}
+		ok   bool
+	}{
+		{nil, nil, false},
+		{errors.New("plain"), nil, false},
+		{codeError{3}, 3, true},
+		{fmt.Errorf("outer: %w", fmt.Errorf("inner: %w", codeError{5})), 5, true},
+		{fmt.Errorf("wrapped: %w", stringCodeError{}), "NOT_FOUND", true},
+		{fmt.Errorf("not wrapped: %v", codeError{9}), nil, false},
+	} {
+		if code, ok := ErrorCode(c.err); code != c.code || ok != c.ok {
+			t.Errorf("ErrorCode(%v) = %v, %v; want %v, %v", c.err, code, ok, c.code, c.ok)
+		}
+	}
+}
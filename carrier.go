@@ -0,0 +1,66 @@
+package hlog
+
+import (
+	"context"
+	"strings"
+)
+
+// TraceCarrier is the header storage of a transport that trace ids are injected into and
+// extracted from. http.Header satisfies it; MapCarrier and MetadataCarrier adapt thrift
+// headers and grpc metadata.
+type TraceCarrier interface {
+	Get(key string) string
+	Set(key, value string)
+}
+
+// MapCarrier adapts a map[string]string such as thrift THeader headers
+type MapCarrier map[string]string
+
+func (c MapCarrier) Get(key string) string {
+	if v, ok := c[key]; ok {
+		return v
+	}
+	for k, v := range c {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+func (c MapCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+// MetadataCarrier adapts a map[string][]string with lowercase keys, a grpc metadata.MD
+// converts to it directly: hlog.MetadataCarrier(md)
+type MetadataCarrier map[string][]string
+
+func (c MetadataCarrier) Get(key string) string {
+	if v := c[strings.ToLower(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c MetadataCarrier) Set(key, value string) {
+	c[strings.ToLower(key)] = []string{value}
+}
+
+// InjectTrace writes the trace carried by ctx, or the logger's own, into carrier
+func (l *Logger) InjectTrace(ctx context.Context, carrier TraceCarrier) string {
+	f := l.Formatter.(*DefaultLogFormatter)
+	trace := f.contextTrace(ctx)
+	f.injectTrace(carrier, trace)
+	return trace.TraceId
+}
+
+// ExtractTrace returns a copy of ctx carrying the trace found in carrier, or a new one
+func (l *Logger) ExtractTrace(ctx context.Context, carrier TraceCarrier) context.Context {
+	f := l.Formatter.(*DefaultLogFormatter)
+	t, ok := f.extractTrace(carrier)
+	if !ok {
+		t = f.newTrace()
+	}
+	return ContextWithTrace(ctx, t)
+}
@@ -0,0 +1,117 @@
+package hlog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestMapCarrier(t *testing.T) {
+	c := MapCarrier{"X-Request-Id": "upper", "x-request-id": "lower", "TraceParent": "tp"}
+	for key, want := range map[string]string{
+		"X-Request-Id": "upper", //精确匹配优先
+		"x-request-id": "lower",
+		"traceparent":  "tp", //大小写不同时回退到忽略大小写的匹配
+		"TRACEPARENT":  "tp",
+		"tracestate":   "",
+	} {
+		if got := c.Get(key); got != want {
+			t.Errorf("Get(%q) = %q, want %q", key, got, want)
+		}
+	}
+	c.Set("TraceState", "a=b")
+	if c["TraceState"] != "a=b" {
+		t.Errorf("Set changed the key: %v", c)
+	}
+}
+
+func TestMetadataCarrier(t *testing.T) {
+	c := MetadataCarrier{"empty": nil}
+	c.Set("X-Request-Id", "id")
+	if v, ok := c["x-request-id"]; !ok || len(v) != 1 || v[0] != "id" {
+		t.Errorf("Set did not store a lowercase key: %v", c)
+	}
+	if got := c.Get("X-REQUEST-ID"); got != "id" {
+		t.Errorf("Get with a mixed-case key = %q", got)
+	}
+	if got := c.Get("empty"); got != "" {
+		t.Errorf("Get of an empty value = %q", got)
+	}
+}
+
+// newRpcTestLogger 返回输出json格式日志到buf、同时传播两种trace header的Logger
+func newRpcTestLogger(buf *syncBuffer) *Logger {
+	c := &Config{level: logrus.DebugLevel, TraceHeader: "X-Request-Id", TracePropagation: TracePropagationBoth,
+		Format: &FormatterConfig{Type: FormatTypeJson, FullTimestamp: true}}
+	return newLogger(c, buf, 1)
+}
+
+func TestRpcInterceptorRoundTrip(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		err  error
+		tag  string
+	}{
+		{"success", nil, LogTagRpcOk},
+		{"failure", codeError{5}, LogTagRpcErr},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			clientBuf, serverBuf := &syncBuffer{}, &syncBuffer{}
+			client := newRpcTestLogger(clientBuf).NewRpcInterceptor("")
+			server := newRpcTestLogger(serverBuf).NewRpcInterceptor("")
+			ctx := ContextWithTrace(context.Background(), &Trace{TraceId: "trace-rt"})
+
+			md := MetadataCarrier{}
+			err := client.Client(ctx, md, "/svc/Get", func(ctx context.Context) error {
+				//模拟传输：服务端从同一份metadata中解析trace
+				return server.Server(context.Background(), md, "/svc/Get", func(ctx context.Context) error {
+					EntryFromContext(ctx).Info("handling")
+					return c.err
+				})
+			})
+			if err != c.err {
+				t.Fatalf("client returned %v, want %v", err, c.err)
+			}
+			if md.Get("x-request-id") != "trace-rt" || len(md.Get(TraceParentHeader)) == 0 {
+				t.Errorf("client injected %v", md)
+			}
+
+			serverLines := serverBuf.jsonLines(t)
+			if len(serverLines) != 2 || serverLines[0][jsonKeyMsg] != "handling" {
+				t.Fatalf("server logged %v", serverLines)
+			}
+			clientLines := clientBuf.jsonLines(t)
+			for _, line := range []map[string]interface{}{serverLines[0], serverLines[1], clientLines[0]} {
+				if line[jsonKeyTraceid] != "trace-rt" {
+					t.Errorf("line %v lost the trace", line)
+				}
+			}
+			for side, line := range map[string]map[string]interface{}{rpcSideServer: serverLines[1], rpcSideClient: clientLines[0]} {
+				if line[jsonKeyTag] != c.tag || line["rpc_side"] != side || line["method"] != "/svc/Get" {
+					t.Errorf("%s logged %v, want tag %s", side, line, c.tag)
+				}
+				if c.err != nil && line[LogCodeName] != float64(5) {
+					t.Errorf("%s logged code %v", side, line[LogCodeName])
+				}
+			}
+		})
+	}
+}
+
+func TestRpcInterceptorServerPanic(t *testing.T) {
+	buf := &syncBuffer{}
+	server := newRpcTestLogger(buf).NewRpcInterceptor(LogTagThriftOk)
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Errorf("recovered %v, want the handler's panic", p)
+		}
+		lines := buf.jsonLines(t)
+		if len(lines) != 1 || lines[0][jsonKeyTag] != LogTagThriftErr || lines[0][jsonKeyLevel] != "ERROR" {
+			t.Errorf("panicking call logged %v", lines)
+		}
+	}()
+	server.Server(context.Background(), MapCarrier{}, "Ping", func(context.Context) error {
+		panic("boom")
+	})
+}
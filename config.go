@@ -1,14 +1,36 @@
 package hlog
 
-import "github.com/sirupsen/logrus"
+import (
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sirupsen/logrus"
+)
 
 type Config struct {
-	Level       string
-	level       logrus.Level
-	TraceHeader string
-	Kafka       *KafkaConfig
-	File        *FileConfig
-	Format      *FormatterConfig
+	Level            string
+	level            logrus.Level
+	TraceHeader      string
+	TracePropagation string //trace的传播方式：legacy(默认)、w3c、both
+	Kafka            *KafkaConfig
+	File             *FileConfig
+	Format           *FormatterConfig
+	Sinks            []*SinkConfig //配置后日志只输出到这些sink，Kafka与File不再生效
+}
+
+// SinkConfig 一个输出目标的配置，每个sink有自己的级别、格式与缓冲
+type SinkConfig struct {
+	Type    string                 //file、kafka或通过RegisterSink注册的类型
+	Level   string                 //此sink的日志级别，为空使用Config.Level
+	Format  *FormatterConfig       //此sink的日志格式，为空使用Config.Format
+	File    *FileConfig            //type为file时的配置，缓冲由QueueSize、Overflow等配置
+	Kafka   *KafkaConfig           //type为kafka时的配置，缓冲由BufferSize、Overflow等配置
+	Options map[string]interface{} //自定义sink的配置
+	//⤵以下为NewWriterSink的缓冲配置
+	QueueSize    int    //写入队列长度，默认10000
+	Overflow     string //队列满时的策略：drop_newest(默认)、drop_oldest、block
+	BlockTimeout int64  //block策略下最多等待多少毫秒，不大于0则一直等待
 }
 
 type KafkaConfig struct {
@@ -18,20 +40,109 @@ type KafkaConfig struct {
 	App            string
 	AppName        string
 	EnvName        string
+	//⤵以下为producer投递配置，不配置时保持不等待确认、snappy压缩、每500ms发送一批
+	RequiredAcks   string //broker确认方式：none(默认)、leader、all
+	Compression    string //压缩方式：none、gzip、snappy(默认)、lz4、zstd
+	FlushFrequency int64  //每多少毫秒发送一批，默认500
+	FlushMessages  int    //攒够多少条消息发送一批
+	FlushBytes     int    //攒够多少字节发送一批
+	MaxRetries     int    //发送失败的重试次数，默认3，小于0不重试
+	RetryBackoff   int64  //重试间隔(毫秒)，默认100
+	Idempotent     bool   //开启幂等producer，要求acks为all、Version不低于0.11
+	Version        string //kafka版本，如"2.1.0"
+	//⤵以下为topic路由配置，按顺序第一条匹配的路由生效，都不匹配时使用Topic，entry中字符串类型的topic字段优先于路由
+	Routes []KafkaRoute
+	//⤵以下为消息头配置，可选level、tag、trace_id、app、app_name、env、hostname，其他名字取entry中的同名字段
+	Headers []string
+	//⤵以下为分区配置
+	PartitionKey   string //分区key的取值方式：time(默认)、trace_id、field、hostname、round_robin、none
+	PartitionField string //PartitionKey为field时使用的字段名
+	//⤵以下为连接安全配置
+	TLS  *KafkaTLSConfig  //连接broker时使用TLS
+	SASL *KafkaSASLConfig //连接broker时使用SASL认证，通常与TLS一起使用
+	//每条消息投递成功/失败后的回调，可用于统计，回调需尽快返回
+	OnSuccess func(msg *sarama.ProducerMessage) `json:"-"`
+	OnError   func(err *sarama.ProducerError)   `json:"-"`
+	//⤵以下为本地spool配置，kafka不可用或producer忙时消息先写入本地文件，恢复后按顺序重放
+	SpoolDir     string //spool文件目录，为空不开启
+	SpoolMaxSize int64  //spool文件总大小上限，默认为MB，默认1024，超过后丢弃新消息
+	//⤵以下为缓冲区配置，Fire先将消息放入缓冲区，再由后台协程交给producer
+	BufferSize   int    //缓冲区长度，默认10000
+	Overflow     string //缓冲区满时的策略：drop_newest(默认)、drop_oldest、block
+	BlockTimeout int64  //block策略下最多等待多少毫秒，不大于0则一直等待
+	CloseTimeout int64  //Logger.Close时最多等待多少毫秒让消息投递完成，默认5000
+}
+
+type KafkaTLSConfig struct {
+	Enable             bool
+	CAFile             string //服务端证书的CA，为空使用系统CA
+	CertFile           string //客户端证书，双向认证时配置
+	KeyFile            string //客户端证书的私钥
+	InsecureSkipVerify bool   //不校验服务端证书，仅用于测试
+	ServerName         string //校验服务端证书时使用的域名，为空使用连接的地址
+}
+
+type KafkaSASLConfig struct {
+	Mechanism string //PLAIN(默认)、SCRAM-SHA-256、SCRAM-SHA-512
+	User      string
+	Password  string
+}
+
+// KafkaRoute 配置的条件全部满足时，日志写入Topic，未配置的条件不参与匹配
+type KafkaRoute struct {
+	Topic  string
+	Levels []string //日志级别，如["error", "fatal"]
+	Tags   []string //日志tag，如["_com_request_in", "_com_request_out"]
+	Field  string   //entry中的字段名，只配置Field时字段存在即匹配
+	Value  string   //字段值，与字段值的%v格式比较
 }
 
 type FileConfig struct {
 	FileName string //加后缀之前的文件命名
-	//⤵以下均为rotate配置，没设interval没用
-	Interval  int64 //每多少小时切分一次日志，不大于24
-	MaxAge    int64 //最多保存多少天的日志文件
-	MaxSize   int64 //最多保存多大的日志文件，默认为MB
-	LocalTime bool  //是否使用UTC时间来命名日志文件
+	//⤵以下均为rotate配置，没设interval/rotateInterval和maxFileSize没用
+	Interval       int64  //每多少小时切分一次日志，不大于24
+	RotateInterval string //切分周期，如"15m"、"1h"、"24h"，优先于Interval，最小为1分钟，超过一天时须为整天
+	TimeFormat     string //文件名中的时间格式，默认按切分周期选用200601021504、2006010215或20060102
+	MaxFileSize    int64  //单个日志文件的大小上限，默认为MB，超过后切出带序号的分段，如app-2026101715.1.log
+	MaxAge         int64  //最多保存多少天的日志文件
+	MaxSize        int64  //最多保存多大的日志文件，默认为MB
+	MaxBackups     int64  //最多保存多少个旧日志文件，当前正在写入的文件不计入
+	LocalTime      bool   //是否使用UTC时间来命名日志文件
+	Compress       string //切分后的旧日志的压缩方式：gzip、zstd，为空不压缩
+	//⤵以下为写入队列配置
+	QueueSize     int    //写入队列长度，默认100000
+	Overflow      string //队列满时的策略：drop_newest(默认)、drop_oldest、block、spill
+	BlockTimeout  int64  //block策略下最多等待多少毫秒，不大于0则一直等待
+	SpillFileName string //spill策略下的旁路文件，默认为FileName加.spill后缀
+	BufferSize    int    //写入缓冲区大小(字节)，缓冲区满时落盘，默认256KB
+	FlushInterval int64  //缓冲区定时落盘的间隔(毫秒)，默认200
 }
 
 type FormatterConfig struct {
-	FullTimestamp   bool
-	TimestampFormat string
-	DisableSorting  bool
-	DisableLog      bool
+	Type            string //输出格式：text(默认)、json
+	FullTimestamp   bool   //输出完整时间，为false时输出进程启动以来的秒数
+	TimestampFormat string //完整时间的格式，默认为DefaultTimestampFormat
+	DisableSorting  bool   //不对自定义字段排序
+	DisableLog      bool   //日志级别不低于Error时，只输出access日志与注册过的失败tag的日志
+}
+
+// validate 校验配置，并将非法或缺省的值替换为默认值
+func (fc *FormatterConfig) validate() (err error) {
+	switch fc.Type {
+	case "":
+		fc.Type = FormatTypeText
+	case FormatTypeText, FormatTypeJson:
+	default:
+		err = fmt.Errorf("unknown format type %q", fc.Type)
+		fc.Type = FormatTypeText
+	}
+	if len(fc.TimestampFormat) == 0 {
+		fc.TimestampFormat = DefaultTimestampFormat
+	} else if time.Unix(0, 0).UTC().Format(fc.TimestampFormat) == fc.TimestampFormat { //不含任何时间元素
+		if err == nil {
+			err = fmt.Errorf("invalid timestamp format %q", fc.TimestampFormat)
+		}
+		fc.TimestampFormat = DefaultTimestampFormat
+	}
+	return err
 }
@@ -4,12 +4,22 @@ const (
 	DefaultTimestampFormat      = "2006-01-02 15:04:05.000-0700"
 	DefaultKafkaTimestampFormat = "2006-01-02T15:04:05.999Z07:00"
 	DefaultTraceHeader          = "default-header-rid"
+	TraceParentHeader           = "traceparent"
+	TraceStateHeader            = "tracestate"
+)
+
+// trace在服务间的传播方式
+const (
+	TracePropagationLegacy = "legacy" //只使用TraceHeader，默认
+	TracePropagationW3C    = "w3c"    //只使用W3C traceparent/tracestate
+	TracePropagationBoth   = "both"   //同时发送两种header，解析时优先traceparent，没有时回退到TraceHeader
 )
 
 const (
 	LogOK       string = "ok"
 	LogTag      string = "tag"
 	LogBegin    string = "___TIME___"
+	LogCaller   string = "___CALLER___" //替代自动获取的调用位置，值为空时不输出调用位置
 	LogTagUndef string = "_undef"
 )
 
@@ -20,6 +30,7 @@ const (
 	LogTagAccessIn  string = "_com_request_in"
 	LogTagAccessOut string = "_com_request_out"
 	LogTagMysqlOk   string = "_com_mysql_success"
+	LogTagRpcOk     string = "_com_rpc_success"
 )
 
 const (
@@ -27,13 +38,20 @@ const (
 	LogTagThriftErr  string = "_com_thrift_failure"
 	LogTagRedisErr   string = "_com_redis_failure"
 	LogTagMysqlErr   string = "_com_mysql_failure"
+	LogTagRpcErr     string = "_com_rpc_failure"
 )
 
+// TagDescSuccMapErr maps the built-in success tags to their failure tags.
+//
+// Deprecated: it is copied into the tag registry at init and not consulted afterwards,
+// register custom pairs with RegisterTagPair or RegisterTag and look them up with
+// LookupTag or FailureTag.
 var TagDescSuccMapErr = map[string]string{
 	LogTagRequestOk: LogTagRequestErr,
 	LogTagThriftOk:  LogTagThriftErr,
 	LogTagRedisOk:   LogTagRedisErr,
 	LogTagMysqlOk:   LogTagMysqlErr,
+	LogTagRpcOk:     LogTagRpcErr,
 }
 
 const (
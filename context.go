@@ -0,0 +1,90 @@
+package hlog
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+type contextKey int
+
+const (
+	traceContextKey contextKey = iota
+	logidContextKey
+	fieldsContextKey
+	loggerContextKey
+)
+
+// ContextWithTrace returns a copy of ctx carrying t. Entries logged with
+// Logger.WithContext(ctx) use this trace instead of the one held by the formatter,
+// so concurrent requests on one Logger no longer need Clone.
+func ContextWithTrace(ctx context.Context, t *Trace) context.Context {
+	trace := *t
+	return context.WithValue(ctx, traceContextKey, &trace)
+}
+
+// NewTraceContext returns a copy of ctx carrying a freshly generated trace id
+func NewTraceContext(ctx context.Context) context.Context {
+	return ContextWithTrace(ctx, &Trace{TraceId: calculateTraceId(getIp())})
+}
+
+// TraceFromContext returns the trace attached to ctx, the returned value must not be modified
+func TraceFromContext(ctx context.Context) (*Trace, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	t, ok := ctx.Value(traceContextKey).(*Trace)
+	return t, ok
+}
+
+// ContextWithLogid returns a copy of ctx carrying logid, which overrides the logger's workerId
+func ContextWithLogid(ctx context.Context, logid int64) context.Context {
+	return context.WithValue(ctx, logidContextKey, logid)
+}
+
+// LogidFromContext returns the logid attached to ctx
+func LogidFromContext(ctx context.Context) (int64, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	logid, ok := ctx.Value(logidContextKey).(int64)
+	return logid, ok
+}
+
+// ContextWithFields returns a copy of ctx carrying fields merged with the ones already in ctx
+func ContextWithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	merged := logrus.Fields{}
+	for k, v := range FieldsFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, fieldsContextKey, merged)
+}
+
+// FieldsFromContext returns the fields attached to ctx, the returned map must not be modified
+func FieldsFromContext(ctx context.Context) logrus.Fields {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(fieldsContextKey).(logrus.Fields)
+	return fields
+}
+
+// ContextWithLogger returns a copy of ctx carrying l, see EntryFromContext
+func ContextWithLogger(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey, l)
+}
+
+// EntryFromContext returns an entry of the logger attached to ctx, bound to ctx so that the
+// trace, logid and fields carried by ctx are logged. Without a logger in ctx it falls back
+// to logrus' standard logger.
+func EntryFromContext(ctx context.Context) *logrus.Entry {
+	if ctx != nil {
+		if l, ok := ctx.Value(loggerContextKey).(*Logger); ok {
+			return l.WithContext(ctx)
+		}
+	}
+	return logrus.NewEntry(logrus.StandardLogger()).WithContext(ctx)
+}
@@ -0,0 +1,71 @@
+package hlog
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestContextConcurrentRequests(t *testing.T) {
+	const requests, lines = 20, 50
+	buf := &syncBuffer{}
+	l := newTestLogger(buf)
+	l.SetTraceId("shared")
+
+	var wg sync.WaitGroup
+	for r := 0; r < requests; r++ {
+		wg.Add(1)
+		go func(r int) { //每个请求共用一个Logger，不Clone
+			defer wg.Done()
+			ctx := ContextWithTrace(context.Background(), &Trace{TraceId: fmt.Sprintf("trace-%d", r)})
+			ctx = ContextWithLogid(ctx, int64(1000+r))
+			ctx = ContextWithFields(ctx, logrus.Fields{"req": r})
+			ctx = ContextWithLogger(ctx, l)
+			for i := 0; i < lines; i++ {
+				if i%2 == 0 {
+					l.WithContext(ctx).WithField("n", i).Info("hello")
+				} else {
+					EntryFromContext(ctx).WithField("n", i).Info("hello")
+				}
+			}
+		}(r)
+	}
+	wg.Add(1)
+	go func() { //不带context的日志使用Logger自己的trace
+		defer wg.Done()
+		for i := 0; i < lines; i++ {
+			l.Info("no context")
+		}
+	}()
+	wg.Wait()
+
+	counts := make(map[float64]int)
+	for _, line := range buf.jsonLines(t) {
+		req, ok := line["req"].(float64)
+		if !ok {
+			if line[jsonKeyTraceid] != "shared" || line[jsonKeyLogid] != float64(1) {
+				t.Fatalf("line without context has trace %v logid %v", line[jsonKeyTraceid], line[jsonKeyLogid])
+			}
+			counts[-1]++
+			continue
+		}
+		if want := fmt.Sprintf("trace-%d", int(req)); line[jsonKeyTraceid] != want {
+			t.Fatalf("request %v logged trace %v", req, line[jsonKeyTraceid])
+		}
+		if line[jsonKeyLogid] != 1000+req {
+			t.Fatalf("request %v logged logid %v", req, line[jsonKeyLogid])
+		}
+		counts[req]++
+	}
+	if len(counts) != requests+1 {
+		t.Fatalf("got lines of %d requests, want %d", len(counts), requests+1)
+	}
+	for req, n := range counts {
+		if n != lines {
+			t.Errorf("request %v logged %d lines, want %d", req, n, lines)
+		}
+	}
+}
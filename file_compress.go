@@ -0,0 +1,87 @@
+package hlog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// 已切分日志文件的压缩方式
+const (
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+const compressTmpSuffix = ".tmp"
+
+var compressSuffixes = map[string]string{
+	CompressGzip: ".gz",
+	CompressZstd: ".zst",
+}
+
+func validCompress(compress string) bool {
+	_, ok := compressSuffixes[compress]
+	return ok
+}
+
+// stripCompressSuffix 去掉文件名上的压缩后缀，并返回该文件是否已压缩
+func stripCompressSuffix(name string) (string, bool) {
+	for _, suffix := range compressSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return name[:len(name)-len(suffix)], true
+		}
+	}
+	return name, false
+}
+
+// compressLogFile 将name压缩为带压缩后缀的新文件，成功后删除原文件
+func (fw *FileWriter) compressLogFile(name string) (err error) {
+	dst := name + compressSuffixes[fw.Compress]
+	tmp := dst + compressTmpSuffix
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, stat.Mode())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(tmp)
+		}
+	}()
+	var w io.WriteCloser
+	switch fw.Compress {
+	case CompressZstd:
+		if w, err = zstd.NewWriter(out); err != nil {
+			return err
+		}
+	default:
+		w = gzip.NewWriter(out)
+	}
+	if _, err = io.Copy(w, src); err != nil {
+		w.Close()
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, dst); err != nil {
+		return fmt.Errorf("rename compressed log %s err: %v", tmp, err)
+	}
+	return os.Remove(name)
+}
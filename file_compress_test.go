@@ -0,0 +1,71 @@
+package hlog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// touchFiles 在dir下创建空文件
+func touchFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLastSegmentAfterCompressed(t *testing.T) {
+	for _, c := range []struct {
+		files []string
+		want  int
+	}{
+		{nil, 0},
+		{[]string{"app-20261017.log"}, 0},
+		{[]string{"app-20261017.log.gz"}, 1},
+		{[]string{"app-20261017.log", "app-20261017.1.log.gz"}, 2},
+		{[]string{"app-20261017.log.gz", "app-20261017.1.log"}, 1},
+		{[]string{"app-20261017.log.gz", "app-20261017.1.log.zst", "app-20261017.2.log.zst", "app-20261016.5.log"}, 3},
+	} {
+		dir := t.TempDir()
+		touchFiles(t, dir, c.files...)
+		fw := &FileWriter{FileConfig: &FileConfig{FileName: filepath.Join(dir, "app.log")}}
+		if got := fw.lastSegment(filepath.Join(dir, "app-20261017.log")); got != c.want {
+			t.Errorf("%v: resume at segment %d, want %d", c.files, got, c.want)
+		}
+	}
+}
+
+func TestOldLogFilesMixedNames(t *testing.T) {
+	dir := t.TempDir()
+	touchFiles(t, dir,
+		"app-20261015.log", "app-20261015.3.log.gz",
+		"app-20261016.log.gz", "app-20261016.1.log.zst", "app-20261016.2.log",
+		"app-20261017.log", "app-20261017.1.log.gz",
+		"app-20261016.log.gz.tmp", "other.log", "app-bad.log")
+	fw := &FileWriter{FileConfig: &FileConfig{FileName: filepath.Join(dir, "app.log"), RotateInterval: "24h"}}
+	fw.interval, fw.timeFormat = fw.parseRotateInterval()
+	files, err := fw.oldLogFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range files {
+		got = append(got, fmt.Sprintf("%s:%d:%v", f.Name(), f.segment, f.compressed))
+	}
+	want := []string{
+		"app-20261017.1.log.gz:1:true",
+		"app-20261017.log:0:false",
+		"app-20261016.2.log:2:false",
+		"app-20261016.1.log.zst:1:true",
+		"app-20261016.log.gz:0:true",
+		"app-20261015.3.log.gz:3:true",
+		"app-20261015.log:0:false",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
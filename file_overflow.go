@@ -0,0 +1,180 @@
+package hlog
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// 写入队列满时的处理策略
+const (
+	OverflowDropNewest = "drop_newest" //丢弃当前要写入的日志，默认策略
+	OverflowDropOldest = "drop_oldest" //丢弃队列中最早的日志，为当前日志腾出位置
+	OverflowBlock      = "block"       //阻塞等待队列有空位，可配置最长等待时间
+	OverflowSpill      = "spill"       //将写不进队列的日志追加到旁路文件中
+)
+
+const defaultSpillFileSuffix = ".spill"
+
+// FileWriterStats 统计FileWriter写入、丢弃、溢出到旁路文件的日志行数
+type FileWriterStats struct {
+	Written uint64 //已写入输出目标的行数，缓冲区中的行在落盘后才计入
+	Dropped uint64 //因队列满而丢弃的行数
+	Spilled uint64 //因队列满而写入旁路文件的行数
+	Failed  uint64 //写入或落盘失败的行数
+}
+
+type fileWriterCounter struct {
+	written atomic.Uint64
+	dropped atomic.Uint64
+	spilled atomic.Uint64
+	failed  atomic.Uint64
+}
+
+func validOverflowPolicy(policy string) bool {
+	switch policy {
+	case OverflowDropNewest, OverflowDropOldest, OverflowBlock, OverflowSpill:
+		return true
+	}
+	return false
+}
+
+func (fw *FileWriter) overflowPolicy() string {
+	if len(fw.Overflow) == 0 {
+		return OverflowDropNewest
+	}
+	return fw.Overflow
+}
+
+func (fw *FileWriter) queueSize() int {
+	if fw.QueueSize <= 0 {
+		return defaultQueueSize
+	}
+	return fw.QueueSize
+}
+
+func (fw *FileWriter) bufferSize() int {
+	if fw.BufferSize <= 0 {
+		return defaultBufferSize
+	}
+	return fw.BufferSize
+}
+
+func (fw *FileWriter) flushInterval() time.Duration {
+	if fw.FlushInterval <= 0 {
+		return defaultFlushInterval
+	}
+	return time.Duration(fw.FlushInterval) * time.Millisecond
+}
+
+func (fw *FileWriter) spillFileName() string {
+	if len(fw.SpillFileName) > 0 {
+		return fw.SpillFileName
+	}
+	if len(fw.FileName) > 0 {
+		return fw.FileName + defaultSpillFileSuffix
+	}
+	return ""
+}
+
+// enqueueOverflow 将v放入queue，queue满时按照policy处理：drop_oldest丢弃最早的元素腾出位置并计入dropped，
+// block最多等待blockTimeout(不大于0则一直等待)或closeChan关闭；返回v是否入队，未入队的v由调用方处理
+func enqueueOverflow[T any](queue chan T, v T, policy string, blockTimeout time.Duration,
+	closeChan <-chan struct{}, dropped *atomic.Uint64) bool {
+	select {
+	case queue <- v:
+		return true
+	default:
+	}
+	switch policy {
+	case OverflowDropOldest:
+		for {
+			select {
+			case queue <- v:
+				return true
+			default:
+			}
+			select {
+			case <-queue:
+				dropped.Add(1)
+			default:
+			}
+		}
+	case OverflowBlock:
+		var timeout <-chan time.Time
+		if blockTimeout > 0 {
+			timer := time.NewTimer(blockTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case queue <- v:
+			return true
+		case <-timeout:
+		case <-closeChan:
+		}
+	}
+	return false
+}
+
+// enqueue 将日志放入写入队列，队列满时按照配置的策略处理，返回日志是否被保留
+func (fw *FileWriter) enqueue(p []byte) bool {
+	policy := fw.overflowPolicy()
+	if enqueueOverflow(fw.queue, p, policy, time.Duration(fw.BlockTimeout)*time.Millisecond, fw.closeChan, &fw.counter.dropped) {
+		return true
+	}
+	if policy == OverflowSpill {
+		if err := fw.spill(p); err == nil {
+			fw.counter.spilled.Add(1)
+			return true
+		} else {
+			fmt.Printf("spill log err: %v\n", err)
+		}
+	}
+	fw.counter.dropped.Add(1)
+	return false
+}
+
+// spill 将日志直接追加到旁路文件，旁路文件在第一次溢出时才打开
+func (fw *FileWriter) spill(p []byte) error {
+	fw.spillMu.Lock()
+	defer fw.spillMu.Unlock()
+	select {
+	case <-fw.closeChan:
+		return fmt.Errorf("file writer closed")
+	default:
+	}
+	if fw.spillFile == nil {
+		name := fw.spillFileName()
+		if len(name) == 0 {
+			return fmt.Errorf("no spill file configured")
+		}
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			return err
+		}
+		fw.spillFile = f
+	}
+	_, err := fw.spillFile.Write(p)
+	return err
+}
+
+func (fw *FileWriter) closeSpill() {
+	fw.spillMu.Lock()
+	defer fw.spillMu.Unlock()
+	if fw.spillFile != nil {
+		fw.spillFile.Close()
+		fw.spillFile = nil
+	}
+}
+
+// Stats 返回此Writer的写入统计
+func (fw *FileWriter) Stats() FileWriterStats {
+	return FileWriterStats{
+		Written: fw.counter.written.Load(),
+		Dropped: fw.counter.dropped.Load(),
+		Spilled: fw.counter.spilled.Load(),
+		Failed:  fw.counter.failed.Load(),
+	}
+}
@@ -0,0 +1,131 @@
+package hlog
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newQueueFileWriter 返回只有写入队列、没有后台协程的FileWriter，队列不会被取走，用于检查队列满时的策略
+func newQueueFileWriter(fc *FileConfig, queueSize int) *FileWriter {
+	return &FileWriter{FileConfig: fc, queue: make(chan []byte, queueSize), closeChan: make(chan struct{})}
+}
+
+// queued 按顺序取出队列中的日志
+func queued(fw *FileWriter) (lines []string) {
+	for {
+		select {
+		case p := <-fw.queue:
+			lines = append(lines, string(p))
+		default:
+			return
+		}
+	}
+}
+
+func TestFileOverflowPolicies(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		fc    FileConfig
+		kept  []bool   //每一行enqueue的返回值
+		queue []string //最后留在队列中的行
+		stats FileWriterStats
+	}{
+		{"drop newest", FileConfig{Overflow: OverflowDropNewest}, []bool{true, true, false},
+			[]string{"a", "b"}, FileWriterStats{Dropped: 1}},
+		{"default drops newest", FileConfig{}, []bool{true, true, false},
+			[]string{"a", "b"}, FileWriterStats{Dropped: 1}},
+		{"drop oldest evicts queued lines", FileConfig{Overflow: OverflowDropOldest}, []bool{true, true, true},
+			[]string{"b", "c"}, FileWriterStats{Dropped: 1}},
+		{"block times out", FileConfig{Overflow: OverflowBlock, BlockTimeout: 50}, []bool{true, true, false},
+			[]string{"a", "b"}, FileWriterStats{Dropped: 1}},
+		{"spill to side file", FileConfig{Overflow: OverflowSpill}, []bool{true, true, true},
+			[]string{"a", "b"}, FileWriterStats{Spilled: 1}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			fc := c.fc
+			fc.SpillFileName = filepath.Join(t.TempDir(), "side.log")
+			fw := newQueueFileWriter(&fc, 2)
+			defer fw.closeSpill()
+			begin := time.Now()
+			for i, line := range []string{"a", "b", "c"} {
+				if kept := fw.enqueue([]byte(line)); kept != c.kept[i] {
+					t.Errorf("enqueue %s returned %v", line, kept)
+				}
+			}
+			if c.fc.Overflow == OverflowBlock && time.Since(begin) < 50*time.Millisecond {
+				t.Errorf("block returned after %s, before BlockTimeout", time.Since(begin))
+			}
+			if got := queued(fw); strings.Join(got, ",") != strings.Join(c.queue, ",") {
+				t.Errorf("queue holds %v, want %v", got, c.queue)
+			}
+			if stats := fw.Stats(); stats != c.stats {
+				t.Errorf("stats %+v, want %+v", stats, c.stats)
+			}
+			b, _ := os.ReadFile(fc.SpillFileName)
+			if want := strings.Repeat("c", int(c.stats.Spilled)); string(b) != want {
+				t.Errorf("side file holds %q, want %q", b, want)
+			}
+		})
+	}
+}
+
+func TestFileOverflowBlockUntilQueued(t *testing.T) {
+	fw := newQueueFileWriter(&FileConfig{Overflow: OverflowBlock}, 1)
+	fw.enqueue([]byte("a"))
+	done := make(chan bool)
+	go func() { done <- fw.enqueue([]byte("b")) }()
+	select {
+	case <-done:
+		t.Fatal("enqueue did not block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-fw.queue
+	if !<-done || fw.Stats().Dropped != 0 {
+		t.Errorf("blocked line was not queued, stats %+v", fw.Stats())
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestFileWriterFailedStats(t *testing.T) {
+	fw, closeFn := newTestFileWriter(t, &FileConfig{BufferSize: 16})
+	fw.mu.Lock()
+	fw.writer = failWriter{}
+	fw.buf.Reset(fw.writer)
+	fw.mu.Unlock()
+	fw.Write([]byte("short\n"))                      //进入缓冲区，落盘时失败
+	fw.Write([]byte(strings.Repeat("x", 32) + "\n")) //超过缓冲区，直接写入时失败
+	closeFn()
+	if stats := fw.Stats(); stats.Failed != 2 || stats.Written != 0 {
+		t.Errorf("unexpected stats %+v after failed writes", stats)
+	}
+}
+
+func TestLoggerFileStats(t *testing.T) {
+	const n = 100
+	name := filepath.Join(t.TempDir(), "app.log")
+	l := NewLoggerWithConfig(&Config{Level: "info", File: &FileConfig{FileName: name}}, 1)
+	for i := 0; i < n; i++ {
+		l.Info("hello")
+	}
+	l.Debug("filtered by level")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := l.FileStats(); stats != (FileWriterStats{Written: n}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(b), "\n"); lines != n {
+		t.Errorf("file has %d lines, want %d", lines, n)
+	}
+}
@@ -0,0 +1,149 @@
+package hlog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxLegacyInterval = 24
+	minRotateInterval = time.Minute
+	day               = 24 * time.Hour
+)
+
+// parseRotateInterval 解析切分周期与文件名时间格式，RotateInterval优先于按小时配置的Interval
+func (fw *FileWriter) parseRotateInterval() (interval time.Duration, timeFormat string) {
+	if len(fw.RotateInterval) > 0 {
+		d, err := time.ParseDuration(fw.RotateInterval)
+		if err != nil || d <= 0 {
+			fmt.Printf("parse rotate interval %s error: %v, use interval %d instead\n", fw.RotateInterval, err, fw.Interval)
+		} else if d > day && d%day != 0 { //大于一天的周期按整天对齐，不能整天切分
+			fmt.Printf("rotate interval %s is not a whole number of days, use interval %d instead\n", fw.RotateInterval, fw.Interval)
+		} else {
+			interval = d
+			if interval < minRotateInterval {
+				interval = minRotateInterval
+			}
+		}
+	}
+	if interval <= 0 && fw.Interval > 0 {
+		hours := fw.Interval
+		if hours > maxLegacyInterval {
+			hours = maxLegacyInterval
+		}
+		interval = time.Duration(hours) * time.Hour
+	}
+	timeFormat = fw.TimeFormat
+	if len(timeFormat) == 0 {
+		switch {
+		case interval%time.Hour != 0:
+			timeFormat = minuteFileNameTimeFormat
+		case len(fw.RotateInterval) > 0 && interval%day == 0:
+			timeFormat = dayFileNameTimeFormat
+		default:
+			timeFormat = logFileNameTimeFormat
+		}
+	}
+	return interval, timeFormat
+}
+
+// alignTime 将t对齐到所在切分周期的起点
+// 小于一天的周期从当天零点开始计算，不能整除24小时的周期在当天最后一段会变短，第二天重新从零点开始；
+// 大于等于一天的周期按整天计算，从1970-01-01起每若干天为一个周期
+func alignTime(t time.Time, d time.Duration) time.Time {
+	y, m, dd := t.Date()
+	midnight := time.Date(y, m, dd, 0, 0, 0, 0, t.Location())
+	if d < day {
+		return midnight.Add(t.Sub(midnight) / d * d)
+	}
+	days := int64(d / day)
+	epochDays := time.Date(y, m, dd, 0, 0, 0, 0, time.UTC).Unix() / int64(day/time.Second)
+	return midnight.AddDate(0, 0, -int(epochDays%days))
+}
+
+// segmentFileName 返回baseName第segment个分段的文件名，如app-2026101715.log的第1个分段为app-2026101715.1.log
+func segmentFileName(baseName string, segment int) string {
+	if segment <= 0 {
+		return baseName
+	}
+	ext := filepath.Ext(baseName)
+	return baseName[:len(baseName)-len(ext)] + "." + strconv.Itoa(segment) + ext
+}
+
+// splitSegment 将去掉前后缀的文件名拆分为时间部分与分段序号
+func splitSegment(name string) (string, int) {
+	idx := strings.LastIndexByte(name, '.')
+	if idx < 0 {
+		return name, 0
+	}
+	segment, err := strconv.Atoi(name[idx+1:])
+	if err != nil || segment <= 0 {
+		return name, 0
+	}
+	return name[:idx], segment
+}
+
+// segmentFromFileName 判断filename是否为baseName本身或其分段，并返回分段序号
+func segmentFromFileName(filename, baseName string) (int, bool) {
+	if filename == baseName {
+		return 0, true
+	}
+	ext := filepath.Ext(baseName)
+	prefix := baseName[:len(baseName)-len(ext)] + "."
+	if !strings.HasPrefix(filename, prefix) || !strings.HasSuffix(filename, ext) ||
+		len(filename) <= len(prefix)+len(ext) {
+		return 0, false
+	}
+	segment, err := strconv.Atoi(filename[len(prefix) : len(filename)-len(ext)])
+	if err != nil || segment <= 0 {
+		return 0, false
+	}
+	return segment, true
+}
+
+// lastSegment 返回baseName下应继续写入的分段序号，进程重启后继续写入最后一个未压缩的分段
+func (fw *FileWriter) lastSegment(baseName string) int {
+	files, err := ioutil.ReadDir(filepath.Dir(baseName))
+	if err != nil {
+		return 0
+	}
+	var last int
+	base := filepath.Base(baseName)
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		name, compressed := stripCompressSuffix(f.Name())
+		if segment, ok := segmentFromFileName(name, base); ok {
+			if compressed { //已压缩的分段不能再写入，从下一个分段开始
+				segment++
+			}
+			if segment > last {
+				last = segment
+			}
+		}
+	}
+	return last
+}
+
+// needSegment 判断再写入n个字节后当前文件是否会超过单文件大小上限，调用方需持有fw.mu
+func (fw *FileWriter) needSegment(n int) bool {
+	if fw.MaxFileSize <= 0 || fw.file == nil || fw.size <= 0 {
+		return false
+	}
+	return fw.size+int64(n) > fw.MaxFileSize*MEGABYTE
+}
+
+// currentFile 返回当前正在写入的文件名(不含目录)，未写入文件时返回空
+func (fw *FileWriter) currentFile() string {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.file == nil {
+		return ""
+	}
+	return filepath.Base(fw.file.Name())
+}
@@ -0,0 +1,114 @@
+package hlog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeFromFileNameDottedFormat(t *testing.T) {
+	fw := &FileWriter{FileConfig: &FileConfig{FileName: "app.log"}, timeFormat: "2006.01.02"}
+	prefix, ext := fw.prefixAndExt()
+	want := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	for name, segment := range map[string]int{
+		"app-2026.10.17.log":   0,
+		"app-2026.10.17.3.log": 3,
+	} {
+		ts, seg, err := fw.timeFromFileName(name, prefix, ext)
+		if err != nil || !ts.Equal(want) || seg != segment {
+			t.Errorf("%s: got %v, %d, %v; want %v, %d", name, ts, seg, err, want, segment)
+		}
+	}
+	if _, _, err := fw.timeFromFileName("app-2026.10.log", prefix, ext); err == nil {
+		t.Error("expected an error for a name not matching the time format")
+	}
+}
+
+func TestParseRotateInterval(t *testing.T) {
+	for _, c := range []struct {
+		rotate   string
+		interval int64
+		want     time.Duration
+	}{
+		{"15m", 0, 15 * time.Minute},
+		{"10s", 0, minRotateInterval},
+		{"48h", 0, 48 * time.Hour},
+		{"36h", 2, 2 * time.Hour}, //不是整天，回退到Interval
+		{"bad", 0, 0},
+		{"", 30, 24 * time.Hour},
+	} {
+		fw := &FileWriter{FileConfig: &FileConfig{RotateInterval: c.rotate, Interval: c.interval}}
+		if got, _ := fw.parseRotateInterval(); got != c.want {
+			t.Errorf("RotateInterval=%q Interval=%d: got %v, want %v", c.rotate, c.interval, got, c.want)
+		}
+	}
+}
+
+// writeLines 写入n行size字节的日志
+func writeLines(fw *FileWriter, n, size int) {
+	line := []byte(strings.Repeat("x", size-1) + "\n")
+	for i := 0; i < n; i++ {
+		fw.Write(line)
+	}
+}
+
+// mockCurrentTime 让currentTime返回now，须在创建FileWriter之前调用，保证FileWriter先于恢复currentTime关闭
+func mockCurrentTime(t *testing.T, now time.Time) {
+	orig := currentTime
+	currentTime = func() time.Time { return now }
+	t.Cleanup(func() { currentTime = orig })
+}
+
+func TestSegmentRolling(t *testing.T) {
+	mockCurrentTime(t, time.Date(2026, 10, 17, 15, 0, 0, 0, time.UTC))
+	dir := t.TempDir()
+	fw, closeFn := newTestFileWriter(t, &FileConfig{
+		FileName:       filepath.Join(dir, "app.log"),
+		RotateInterval: "24h",
+		MaxFileSize:    1,
+		Overflow:       OverflowBlock,
+	})
+	writeLines(fw, 1100, 1024)
+	closeFn()
+
+	first, err := os.Stat(filepath.Join(dir, "app-20261017.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Size() > MEGABYTE {
+		t.Errorf("first file grew to %d bytes past MaxFileSize", first.Size())
+	}
+	second, err := os.Stat(filepath.Join(dir, "app-20261017.1.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := first.Size() + second.Size(); total != 1100*1024 {
+		t.Errorf("segments hold %d bytes, want %d", total, 1100*1024)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app-20261017.2.log")); err == nil {
+		t.Error("unexpected third segment")
+	}
+}
+
+func TestSegmentRolledBeforeFirstCheckIsMilled(t *testing.T) {
+	mockCurrentTime(t, time.Date(2026, 10, 17, 15, 0, 0, 0, time.UTC))
+	dir := t.TempDir()
+	fw, _ := newTestFileWriter(t, &FileConfig{
+		FileName:       filepath.Join(dir, "app.log"),
+		RotateInterval: "24h",
+		MaxFileSize:    1,
+		Compress:       CompressGzip,
+		Overflow:       OverflowBlock,
+	})
+	writeLines(fw, 1100, 1024) //在fileWatcher第一次检查之前切出分段
+	compressed := filepath.Join(dir, "app-20261017.log.gz")
+	waitFor(t, 3*defaultFileChangeInterval, func() bool {
+		_, err := os.Stat(compressed)
+		return err == nil
+	})
+	if _, err := os.Stat(filepath.Join(dir, "app-20261017.1.log")); err != nil {
+		t.Errorf("current segment was touched by the mill: %v", err)
+	}
+}
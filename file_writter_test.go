@@ -0,0 +1,170 @@
+package hlog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestFileWriter 按fc创建一个FileWriter，返回关闭并等待其写完的函数
+func newTestFileWriter(t testing.TB, fc *FileConfig) (*FileWriter, func()) {
+	t.Helper()
+	wg := &WaitGroupWrapper{}
+	quit := make(chan struct{})
+	fw := newFileWriter(fc, wg, quit)
+	var once sync.Once
+	closeFn := func() {
+		once.Do(func() {
+			close(quit)
+			wg.Wait()
+		})
+	}
+	t.Cleanup(closeFn)
+	return fw, closeFn
+}
+
+func TestFileWritersConcurrent(t *testing.T) {
+	const writers, goroutines, lines = 4, 4, 500
+	dir := t.TempDir()
+	fws := make([]*FileWriter, writers)
+	closers := make([]func(), writers)
+	for i := range fws {
+		fws[i], closers[i] = newTestFileWriter(t, &FileConfig{FileName: filepath.Join(dir, fmt.Sprintf("w%d.log", i))})
+	}
+
+	var wg sync.WaitGroup
+	for i, fw := range fws {
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(i, g int, fw *FileWriter) {
+				defer wg.Done()
+				for n := 0; n < lines; n++ {
+					fw.Write([]byte(fmt.Sprintf("writer=%d goroutine=%d line=%d\n", i, g, n)))
+				}
+			}(i, g, fw)
+		}
+	}
+	wg.Wait()
+	for _, c := range closers {
+		c()
+	}
+
+	for i, fw := range fws {
+		b, err := os.ReadFile(fw.FileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+		if len(got) != goroutines*lines {
+			t.Errorf("%s: got %d lines, want %d", fw.FileName, len(got), goroutines*lines)
+		}
+		prefix := fmt.Sprintf("writer=%d ", i)
+		for _, line := range got {
+			if !strings.HasPrefix(line, prefix) {
+				t.Fatalf("%s: unexpected line %q", fw.FileName, line)
+			}
+		}
+		if stats := fw.Stats(); stats.Written != goroutines*lines || stats.Dropped != 0 {
+			t.Errorf("%s: unexpected stats %+v", fw.FileName, stats)
+		}
+	}
+}
+
+// BenchmarkFileWriterWrite 衡量Write入队并由logWatcher全部落盘的吞吐，以lines/s报告
+func BenchmarkFileWriterWrite(b *testing.B) {
+	line := []byte(strings.Repeat("x", 200) + "\n")
+	fw, closeFn := newTestFileWriter(b, &FileConfig{
+		FileName: filepath.Join(b.TempDir(), "bench.log"),
+		Overflow: OverflowBlock, //不丢日志，测量完整写入的速度
+	})
+	b.SetBytes(int64(len(line)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fw.Write(line)
+	}
+	closeFn()
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "lines/s")
+}
+
+func TestMillRunOnceRetention(t *testing.T) {
+	const kb = 1024
+	now := time.Date(2026, 10, 17, 0, 30, 0, 0, time.UTC)
+	old := map[string]int{ //旧日志文件名与大小
+		"app-20261016.log": 400 * kb,
+		"app-20261015.log": 400 * kb,
+		"app-20261014.log": 400 * kb,
+		"app-20261013.log": 400 * kb,
+		"app-20261010.log": 400 * kb,
+	}
+	const current = "app-20261017.log"
+	for _, c := range []struct {
+		name        string
+		fc          FileConfig
+		currentSize int
+		want        []string //除当前文件外保留的文件
+	}{
+		{"max age", FileConfig{MaxAge: 3}, 0,
+			[]string{"app-20261015.log", "app-20261016.log"}},
+		{"max backups", FileConfig{MaxBackups: 2}, 0,
+			[]string{"app-20261015.log", "app-20261016.log"}},
+		{"max size", FileConfig{MaxSize: 1}, 0,
+			[]string{"app-20261015.log", "app-20261016.log"}},
+		{"max size keeps an oversized current file", FileConfig{MaxSize: 1}, 2 * MEGABYTE,
+			nil},
+		{"age then backups", FileConfig{MaxAge: 5, MaxBackups: 3}, 0,
+			[]string{"app-20261014.log", "app-20261015.log", "app-20261016.log"}},
+		{"age, backups then size", FileConfig{MaxAge: 5, MaxBackups: 3, MaxSize: 1}, 300 * kb,
+			[]string{"app-20261016.log"}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			orig := currentTime
+			currentTime = func() time.Time { return now }
+			t.Cleanup(func() { currentTime = orig })
+
+			dir := t.TempDir()
+			for name, size := range old {
+				if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			fc := c.fc
+			fc.FileName = filepath.Join(dir, "app.log")
+			fc.RotateInterval = "24h"
+			fw, _ := newTestFileWriter(t, &fc)
+			if got := fw.currentFile(); got != current {
+				t.Fatalf("current file %s, want %s", got, current)
+			}
+			if err := os.WriteFile(filepath.Join(dir, current), make([]byte, c.currentSize), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := fw.millRunOnce(); err != nil {
+				t.Fatal(err)
+			}
+			files, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			var hasCurrent bool
+			for _, f := range files {
+				if f.Name() == current {
+					hasCurrent = true
+				} else {
+					got = append(got, f.Name())
+				}
+			}
+			if !hasCurrent {
+				t.Error("current file was removed")
+			}
+			if strings.Join(got, ",") != strings.Join(c.want, ",") {
+				t.Errorf("kept %v, want %v", got, c.want)
+			}
+		})
+	}
+}
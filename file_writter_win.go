@@ -3,6 +3,7 @@
 package hlog
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"io"
@@ -18,8 +19,12 @@ import (
 
 const (
 	logFileNameTimeFormat     = "2006010215"
+	minuteFileNameTimeFormat  = "200601021504"
+	dayFileNameTimeFormat     = "20060102"
 	defaultQueueSize          = 100000
-	defaultFlushDiskTimeout   = 50 * time.Millisecond
+	defaultBufferSize         = 256 * 1024
+	defaultFlushInterval      = 200 * time.Millisecond
+	maxBatchLines             = 1024
 	defaultFileChangeInterval = time.Second
 	defaultFileMillInterval   = time.Hour
 
@@ -27,84 +32,132 @@ const (
 )
 
 var (
-	singleWriter io.Writer = os.Stdout
-	singleQueue            = make(chan []byte, defaultQueueSize)
-	currentTime            = time.Now // currentTime exists so it can be mocked out by tests.
+	currentTime = time.Now // currentTime exists so it can be mocked out by tests.
 )
 
 type FileWriter struct {
 	*FileConfig
 	mu                  sync.Mutex
-	wg                  WaitGroupWrapper
+	wg                  *WaitGroupWrapper
+	queue               chan []byte   //每个Writer独占的写入队列
+	writer              io.Writer     //当前实际写入的目标，文件或stdout
+	buf                 *bufio.Writer //包装writer的缓冲区，由logWatcher批量写入
+	buffered            int           //缓冲区中还未落盘的行数
 	win32FileAttributes uint32
 	file                *os.File
+	interval            time.Duration //解析后的切分周期，不大于0表示不按时间切分
+	timeFormat          string        //文件名中的时间格式
+	baseName            string        //按时间切分得到的文件名，不含分段序号
+	segment             int           //当前文件在baseName下的分段序号，0表示未分段
+	size                int64         //当前文件已写入的大小
 	startMill           sync.Once
 	millCh              chan bool
 	quitChan            chan struct{} //外界用于通知此Writer关闭
 	closeChan           chan struct{} //自身的关闭，用于本身的Close()方法
+	spillMu             sync.Mutex
+	spillFile           *os.File //队列满时的旁路文件，spill策略下使用
+	counter             fileWriterCounter
 }
 
 type logInfo struct {
-	ts time.Time
+	ts         time.Time
+	segment    int
+	compressed bool
 	os.FileInfo
 }
 
-func newFileWriter(fc *FileConfig, wg WaitGroupWrapper, quitChan chan struct{}) (fw *FileWriter) {
+func newFileWriter(fc *FileConfig, wg *WaitGroupWrapper, quitChan chan struct{}) (fw *FileWriter) {
 	if fc == nil {
 		fc = &FileConfig{}
 	}
-	fw = &FileWriter{FileConfig: fc, wg: wg, quitChan: quitChan, closeChan: make(chan struct{})}
+	if len(fc.Compress) > 0 && !validCompress(fc.Compress) {
+		fmt.Printf("invalid file compress %s, disable compression\n", fc.Compress)
+		fc.Compress = ""
+	}
+	if len(fc.Overflow) > 0 && !validOverflowPolicy(fc.Overflow) {
+		fmt.Printf("invalid file overflow policy %s, use %s instead\n", fc.Overflow, OverflowDropNewest)
+		fc.Overflow = OverflowDropNewest
+	}
+	fw = &FileWriter{
+		FileConfig: fc,
+		wg:         wg,
+		quitChan:   quitChan,
+		closeChan:  make(chan struct{}),
+	}
+	fw.interval, fw.timeFormat = fw.parseRotateInterval()
+	fw.queue = make(chan []byte, fw.queueSize())
+	fw.writer = os.Stdout
+	fw.buf = bufio.NewWriterSize(fw.writer, fw.bufferSize())
 	fw.init()
 	return fw
 }
 
 func (fw *FileWriter) init() {
 	if len(fw.FileName) > 0 { //配置了文件输出
-		if err := fw.openFile(fw.currentFileName()); err != nil {
+		baseName := fw.currentFileName()
+		if err := fw.openFile(baseName, fw.lastSegment(baseName)); err != nil {
 			fmt.Printf("OpenFile err: %v, use stdout", err)
 		} else {
-			fw.wg.Wrap(fw.fileWatcher)
+			fileName := fw.file.Name() //logWatcher还没有启动，不会切出新的分段
+			fw.wg.Wrap(func() { fw.fileWatcher(fileName) })
 		}
 	}
 	fw.wg.Wrap(fw.logWatcher)
 }
 
-func (fw *FileWriter) fileWatcher() {
+//定期检查是否需要切换文件。lastFileName为上一次检查时写入的文件，用于发现按大小切出的分段，
+//从init打开的文件开始，这样第一次检查之前切出的分段也能被及时清理/压缩
+func (fw *FileWriter) fileWatcher(lastFileName string) {
 	changeTimer := time.NewTicker(defaultFileChangeInterval)
 	millTimer := time.NewTicker(defaultFileMillInterval)
+	defer changeTimer.Stop()
+	defer millTimer.Stop()
 	for {
 		select {
 		case <-fw.closeChan:
+			fw.mu.Lock()
 			if fw.file != nil {
 				fw.file.Close()
 			}
+			fw.mu.Unlock()
 			if fw.millCh != nil {
 				close(fw.millCh)
 			}
 			return
 		case <-changeTimer.C:
+			fw.mu.Lock()
 			if fw.file == nil { //代表是stdout的输出
+				fw.mu.Unlock()
 				return
 			}
+			fileName, baseName, segment, win32FileAttributes := fw.file.Name(), fw.baseName, fw.segment, fw.win32FileAttributes
+			fw.mu.Unlock()
+			if len(lastFileName) > 0 && lastFileName != fileName {
+				fw.mill() //写入时已切出新的分段，及时清理/压缩旧文件
+			}
+			lastFileName = fileName
 			//文件不存在，或者大小变小，或者时间过了一个周期，都重新打开
 			var needReopen bool
 			currentFileName := fw.currentFileName()
-			if currentFileName != fw.file.Name() {
-				fmt.Printf("rotate file, old file name:%s new file name:%s \n", fw.file.Name(), currentFileName)
+			if currentFileName != baseName {
+				fmt.Printf("rotate file, old file name:%s new file name:%s \n", fileName, currentFileName)
 				needReopen = true
-			} else if stat, err := os.Stat(fw.file.Name()); err != nil && !os.IsExist(err) {
-				fmt.Printf("fileScaner diff, err:%v win32FileAttributes:%v \n", err, fw.win32FileAttributes)
+				baseName, segment = currentFileName, fw.lastSegment(currentFileName)
+			} else if stat, err := os.Stat(fileName); err != nil && !os.IsExist(err) {
+				fmt.Printf("fileScaner diff, err:%v win32FileAttributes:%v \n", err, win32FileAttributes)
 				needReopen = true
 			} else if stat != nil {
 				if fileAttr := stat.Sys(); fileAttr != nil &&
-					fileAttr.(*syscall.Win32FileAttributeData).FileAttributes != fw.win32FileAttributes {
-					fmt.Printf("fileScaner diff, err:%v win32FileAttributes:%v \n", err, fw.win32FileAttributes)
+					fileAttr.(*syscall.Win32FileAttributeData).FileAttributes != win32FileAttributes {
+					fmt.Printf("fileScaner diff, err:%v win32FileAttributes:%v \n", err, win32FileAttributes)
 					needReopen = true
 				}
 			}
 			if needReopen {
-				if err := fw.openFile(currentFileName); err != nil {
+				if err := fw.openFile(baseName, segment); err != nil {
 					fmt.Printf("fileWatcher OpenFile err: %v", err)
+				} else if baseName != fileName {
+					fw.mill()
 				}
 			}
 		case <-millTimer.C:
@@ -113,33 +166,46 @@ func (fw *FileWriter) fileWatcher() {
 	}
 }
 
-//根据当前应使用的filename开启新的文件
-func (fw *FileWriter) openFile(name string) error {
-	if len(name) == 0 {
+//根据当前应使用的filename及分段序号开启新的文件
+func (fw *FileWriter) openFile(baseName string, segment int) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return fw.openFileLocked(baseName, segment)
+}
+
+//调用方需持有fw.mu
+func (fw *FileWriter) openFileLocked(baseName string, segment int) error {
+	if len(baseName) == 0 {
 		return errors.New("invalid current fileName")
-	} else if f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666); err != nil {
+	}
+	name := segmentFileName(baseName, segment)
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
 		return err
-	} else {
-		if fw.file != nil {
-			fw.file.Close()
-		}
-		fw.file = f
-		//修改单例writer，进入临界区
-		fw.mu.Lock()
-		singleWriter = f
-		fw.mu.Unlock()
-		//出临界区
-		stat, err := os.Stat(fw.file.Name())
-		if err == nil && nil != stat {
-			fw.win32FileAttributes = stat.Sys().(*syscall.Win32FileAttributeData).FileAttributes
-		}
-		return nil
 	}
+	fw.flushLocked() //缓冲区中的日志仍属于旧文件
+	old := fw.file
+	fw.file = f
+	fw.writer = f
+	fw.buf.Reset(f)
+	fw.baseName = baseName
+	fw.segment = segment
+	fw.size = 0
+	//旧文件不会再被写入，可以关闭
+	if old != nil {
+		old.Close()
+	}
+	stat, err := os.Stat(name)
+	if err == nil && nil != stat {
+		fw.win32FileAttributes = stat.Sys().(*syscall.Win32FileAttributeData).FileAttributes
+		fw.size = stat.Size()
+	}
+	return nil
 }
 
 //此处异步清理多余的日志
 func (fw *FileWriter) millRunOnce() error {
-	if fw.MaxSize == 0 && fw.MaxAge == 0 {
+	if fw.MaxSize == 0 && fw.MaxAge == 0 && fw.MaxBackups == 0 && len(fw.Compress) == 0 {
 		return nil
 	}
 
@@ -148,6 +214,8 @@ func (fw *FileWriter) millRunOnce() error {
 		return err
 	}
 
+	//三种清理策略依次生效：先按天数，再按个数，最后按总大小；当前正在写入的文件不会被清理
+	current := fw.currentFile()
 	var remove []logInfo
 	//根据最多保留天数清理
 	if fw.MaxAge > 0 {
@@ -155,7 +223,7 @@ func (fw *FileWriter) millRunOnce() error {
 		cutoff := currentTime().Add(-1 * diff)
 		var remaining []logInfo
 		for _, f := range files {
-			if f.ts.Before(cutoff) {
+			if f.Name() != current && f.ts.Before(cutoff) {
 				remove = append(remove, f)
 			} else {
 				remaining = append(remaining, f)
@@ -163,7 +231,23 @@ func (fw *FileWriter) millRunOnce() error {
 		}
 		files = remaining
 	}
-	//根据最多保留日志总大小清理
+	//根据最多保留文件个数清理，当前正在写入的文件不计入个数
+	if fw.MaxBackups > 0 {
+		var remaining []logInfo
+		var backups int64
+		for _, f := range files {
+			if f.Name() != current {
+				if backups >= fw.MaxBackups {
+					remove = append(remove, f)
+					continue
+				}
+				backups++
+			}
+			remaining = append(remaining, f)
+		}
+		files = remaining
+	}
+	//根据最多保留日志总大小清理，当前正在写入的文件计入总大小
 	if fw.MaxSize > 0 {
 		preserved := make(map[string]bool)
 		var remaining []logInfo
@@ -171,7 +255,7 @@ func (fw *FileWriter) millRunOnce() error {
 		for _, f := range files {
 			if !preserved[f.Name()] { //去个重
 				preserved[f.Name()] = true
-				if totalSize+f.Size() < fw.MaxSize*MEGABYTE {
+				if f.Name() == current || totalSize+f.Size() < fw.MaxSize*MEGABYTE {
 					totalSize += f.Size()
 					remaining = append(remaining, f)
 				} else {
@@ -188,6 +272,18 @@ func (fw *FileWriter) millRunOnce() error {
 			err = errRemove
 		}
 	}
+	//压缩保留下来的日志，当前正在写入的文件除外
+	if len(fw.Compress) > 0 {
+		for _, f := range files {
+			if f.compressed || f.Name() == current {
+				continue
+			}
+			errCompress := fw.compressLogFile(filepath.Join(filepath.Dir(fw.FileName), f.Name()))
+			if err == nil && errCompress != nil {
+				err = errCompress
+			}
+		}
+	}
 
 	return err
 }
@@ -213,16 +309,22 @@ func (fw *FileWriter) millRun() {
 }
 
 func (fw *FileWriter) logWatcher() {
+	flushTimer := time.NewTicker(fw.flushInterval())
+	defer flushTimer.Stop()
 	for {
 		select {
-		case msg := <-singleQueue:
-			fw.flush(msg)
+		case msg := <-fw.queue:
+			fw.writeBatch(msg)
+		case <-flushTimer.C:
+			fw.flush()
 		case <-fw.quitChan:
 			for {
 				select {
-				case msg := <-singleQueue:
-					fw.flush(msg)
+				case msg := <-fw.queue:
+					fw.writeBatch(msg)
 				default:
+					fw.flush()
+					fw.closeSpill()
 					fw.Close() //通知其他协程可以关闭文件/chan了
 					return
 				}
@@ -231,29 +333,75 @@ func (fw *FileWriter) logWatcher() {
 	}
 }
 
-func (fw *FileWriter) flush(msg []byte) {
-	done := make(chan bool, 1)
-	fw.wg.Wrap(func() {
-		fw.mu.Lock()
-		defer fw.mu.Unlock()
-		singleWriter.Write(msg)
-		done <- true
-	})
-	select {
-	case <-done:
-		return
-	case <-time.After(defaultFlushDiskTimeout):
+//将msg以及队列中已有的日志一并写入缓冲区，缓冲区满时由bufio自动落盘
+func (fw *FileWriter) writeBatch(msg []byte) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	for i := 0; ; i++ {
+		if fw.needSegment(len(msg)) {
+			if err := fw.openFileLocked(fw.baseName, fw.segment+1); err != nil {
+				fmt.Printf("rotate segment err: %v\n", err)
+			}
+		}
+		fw.writeLocked(msg)
+		if i >= maxBatchLines {
+			return
+		}
+		select {
+		case msg = <-fw.queue:
+		default:
+			return
+		}
+	}
+}
+
+//将一行日志写入缓冲区，缓冲区放不下时先落盘，这样每次落盘都能对应到具体的行，调用方需持有fw.mu
+func (fw *FileWriter) writeLocked(msg []byte) {
+	if len(msg) > fw.buf.Available() && fw.buf.Buffered() > 0 {
+		fw.flushLocked()
+	}
+	n, err := fw.buf.Write(msg)
+	if err != nil { //bufio的错误会一直保留，重置后才能继续写入
+		fmt.Printf("write log err: %v\n", err)
+		fw.counter.failed.Add(1)
+		fw.buf.Reset(fw.writer)
 		return
 	}
+	fw.size += int64(n)
+	if fw.buf.Buffered() == 0 { //超过缓冲区大小的日志直接写入了writer
+		fw.counter.written.Add(1)
+	} else {
+		fw.buffered++
+	}
+}
+
+//将缓冲区中的日志落盘，落盘失败时缓冲区中的行计入failed
+func (fw *FileWriter) flush() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.flushLocked()
+}
+
+//调用方需持有fw.mu
+func (fw *FileWriter) flushLocked() {
+	if err := fw.buf.Flush(); err != nil {
+		fmt.Printf("flush log err: %v\n", err)
+		fw.counter.failed.Add(uint64(fw.buffered))
+		fw.buf.Reset(fw.writer)
+	} else {
+		fw.counter.written.Add(uint64(fw.buffered))
+	}
+	fw.buffered = 0
 }
 
 func (fw *FileWriter) Write(p []byte) (n int, err error) {
-	select {
-	case singleQueue <- p:
-		return len(p), nil
-	default:
+	if len(p) == 0 { //被formatter过滤掉的日志
 		return 0, nil
 	}
+	if !fw.enqueue(p) {
+		return 0, nil
+	}
+	return len(p), nil
 }
 
 func (fw *FileWriter) Close() error {
@@ -262,7 +410,7 @@ func (fw *FileWriter) Close() error {
 }
 
 func (fw *FileWriter) currentFileName() string {
-	if fw.Interval <= 0 { //如果不需要切分，直接返回正常的文件名
+	if fw.interval <= 0 { //如果不需要切分，直接返回正常的文件名
 		return fw.FileName
 	}
 	dir := filepath.Dir(fw.FileName)
@@ -273,23 +421,36 @@ func (fw *FileWriter) currentFileName() string {
 	if !fw.LocalTime {
 		t = t.UTC()
 	}
-	t = t.Add(-time.Hour * time.Duration(int64(t.Hour())%fw.Interval)) //根据interval取整
-	ts := t.Format(logFileNameTimeFormat)
+	t = alignTime(t, fw.interval) //根据interval取整
+	ts := t.Format(fw.timeFormat)
 	return filepath.Join(dir, fmt.Sprintf("%s-%s%s", prefix, ts, ext))
 }
 
-func (fw *FileWriter) timeFromFileName(filename, prefix, ext string) (time.Time, error) {
+//从文件名中解析出时间与分段序号
+func (fw *FileWriter) timeFromFileName(filename, prefix, ext string) (time.Time, int, error) {
 	if !strings.HasPrefix(filename, prefix) {
-		return time.Time{}, errors.New("mismatched prefix")
+		return time.Time{}, 0, errors.New("mismatched prefix")
+	}
+	if !strings.HasSuffix(filename, ext) || len(filename) < len(prefix)+len(ext) {
+		return time.Time{}, 0, errors.New("mismatched extension")
 	}
-	if !strings.HasSuffix(filename, ext) {
-		return time.Time{}, errors.New("mismatched extension")
+	stem := filename[len(prefix) : len(filename)-len(ext)]
+	if ts, err := fw.parseFileTime(stem); err == nil { //时间格式中可能含有'.'，先按未分段的文件名解析
+		return ts, 0, nil
 	}
-	t := filename[len(prefix) : len(filename)-len(ext)]
+	t, segment := splitSegment(stem)
+	if segment <= 0 {
+		return time.Time{}, 0, errors.New("mismatched time format")
+	}
+	ts, err := fw.parseFileTime(t)
+	return ts, segment, err
+}
+
+func (fw *FileWriter) parseFileTime(t string) (time.Time, error) {
 	if fw.LocalTime {
-		return time.ParseInLocation(logFileNameTimeFormat, t, time.Local)
+		return time.ParseInLocation(fw.timeFormat, t, time.Local)
 	}
-	return time.Parse(logFileNameTimeFormat, t)
+	return time.Parse(fw.timeFormat, t)
 }
 
 func (fw *FileWriter) prefixAndExt() (prefix, ext string) {
@@ -312,8 +473,13 @@ func (fw *FileWriter) oldLogFiles() ([]logInfo, error) {
 		if f.IsDir() {
 			continue
 		}
-		if t, err := fw.timeFromFileName(f.Name(), prefix, ext); err == nil {
-			logFiles = append(logFiles, logInfo{t, f})
+		name, compressed := stripCompressSuffix(f.Name())
+		if fw.interval <= 0 { //不按时间切分时，只有按大小切出的分段，以修改时间排序
+			if segment, ok := segmentFromFileName(name, filepath.Base(fw.FileName)); ok {
+				logFiles = append(logFiles, logInfo{f.ModTime(), segment, compressed, f})
+			}
+		} else if t, segment, err := fw.timeFromFileName(name, prefix, ext); err == nil {
+			logFiles = append(logFiles, logInfo{t, segment, compressed, f})
 		}
 
 	}
@@ -323,10 +489,13 @@ func (fw *FileWriter) oldLogFiles() ([]logInfo, error) {
 	return logFiles, nil
 }
 
-// byFormatTime sorts by newest time formatted in the name.
+// byFormatTime sorts by newest time formatted in the name, then by newest segment.
 type byFormatTime []logInfo
 
 func (b byFormatTime) Less(i, j int) bool {
+	if b[i].ts.Equal(b[j].ts) {
+		return b[i].segment > b[j].segment
+	}
 	return b[i].ts.After(b[j].ts)
 }
 
@@ -2,13 +2,16 @@ package hlog
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"math/rand"
 	"net"
 	"os"
+	"reflect"
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"encoding/hex"
@@ -31,9 +34,13 @@ func miniTS() int {
 }
 
 type Trace struct {
-	TraceId   string `json:"traceId,omitempty"`
-	Caller    string `json:"caller,omitempty"`
-	SrcMethod string `json:"srcMethod,omitempty"`
+	TraceId      string `json:"traceId,omitempty"`
+	Caller       string `json:"caller,omitempty"`
+	SrcMethod    string `json:"srcMethod,omitempty"`
+	SpanId       string `json:"spanId,omitempty"`       //本服务的span，w3c模式下使用
+	ParentSpanId string `json:"parentSpanId,omitempty"` //上游traceparent中的parent-id
+	TraceFlags   string `json:"traceFlags,omitempty"`   //上游traceparent中的trace-flags
+	TraceState   string `json:"traceState,omitempty"`   //上游的tracestate，原样向下游传递
 }
 
 var LogFormatter FormatterFunc
@@ -45,37 +52,70 @@ func NewDefaultLogFormatter(c *Config, f logrus.Fields, workerId int64) logrus.F
 	if len(traceHeader) == 0 {
 		traceHeader = DefaultTraceHeader
 	}
+	tracePropagation := c.TracePropagation
+	if len(tracePropagation) == 0 {
+		tracePropagation = TracePropagationLegacy
+	} else if !validTracePropagation(tracePropagation) {
+		fmt.Printf("invalid trace propagation %s, use %s instead\n", tracePropagation, TracePropagationLegacy)
+		tracePropagation = TracePropagationLegacy
+	}
+	fc := FormatterConfig{FullTimestamp: true} //未配置时使用完整时间
+	if c.Format != nil {
+		fc = *c.Format
+	}
+	if err := fc.validate(); err != nil {
+		fmt.Printf("invalid format config: %v, use default instead\n", err)
+	}
 	return &DefaultLogFormatter{
-		WorkerId:        workerId,
-		FullTimestamp:   true,
-		TimestampFormat: DefaultTimestampFormat,
-		DisableSorting:  false,
-		Fields:          f,
-		TraceHeader:     traceHeader,
+		WorkerId:         workerId,
+		Type:             fc.Type,
+		FullTimestamp:    fc.FullTimestamp,
+		TimestampFormat:  fc.TimestampFormat,
+		DisableSorting:   fc.DisableSorting,
+		DisableLog:       fc.DisableLog,
+		Fields:           f,
+		TraceHeader:      traceHeader,
+		TracePropagation: tracePropagation,
 	}
 }
 
 type DefaultLogFormatter struct {
-	WorkerId        int64
-	FullTimestamp   bool
-	TimestampFormat string
-	DisableSorting  bool
-	DisableLog      bool
-	TraceHeader     string
+	WorkerId         int64
+	Type             string
+	FullTimestamp    bool
+	TimestampFormat  string
+	DisableSorting   bool
+	DisableLog       bool
+	TraceHeader      string
+	TracePropagation string
 	Trace
-	Fields logrus.Fields
+	traceMu    sync.RWMutex //保护Trace，同一个Logger可能被多个协程同时使用
+	Fields     logrus.Fields
+	traceOwner *DefaultLogFormatter //sink的formatter使用Logger的formatter的trace
+	discard    bool                 //日志全部由sink输出，Logger自身的Out不需要格式化
 }
 
+var (
+	hlogPackage   = reflect.TypeOf(DefaultLogFormatter{}).PkgPath() + "."
+	logrusPackage = reflect.TypeOf(logrus.Entry{}).PkgPath() + "."
+)
+
+// header 返回打日志的位置，跳过hlog与logrus内部的调用，不依赖固定的调用深度，hook与sink中调用也能找到
 func (f *DefaultLogFormatter) header() string {
-	p, file, line, ok := runtime.Caller(9)
-	for i := 10; i < 13; i++ {
-		if ok {
-			e := runtime.FuncForPC(p)
-			if !strings.Contains(e.Name(), "hlog") &&
-				!strings.Contains(e.Name(), "logrus") {
-				break
-			}
-			p, file, line, ok = runtime.Caller(i)
+	var file string
+	var line int
+	var ok bool
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, hlogPackage) &&
+			!strings.HasPrefix(frame.Function, logrusPackage) {
+			file, line, ok = frame.File, frame.Line, true
+			break
+		}
+		if !more {
+			break
 		}
 	}
 	if !ok {
@@ -95,10 +135,35 @@ func (f *DefaultLogFormatter) header() string {
 	return fmt.Sprintf("%s:%d", file, line)
 
 }
+
+// disabled 开启DisableLog时，只保留access日志与注册过的失败tag的日志
+func (f *DefaultLogFormatter) disabled(entry *logrus.Entry, tag string) bool {
+	if !f.DisableLog || entry.Logger.GetLevel() < logrus.ErrorLevel {
+		return false
+	}
+	return tag != LogTagAccessIn && tag != LogTagAccessOut && !IsFailureTag(tag)
+}
+
+// caller 返回日志的调用位置，entry中带有LogCaller时使用其值
+func (f *DefaultLogFormatter) caller(entry *logrus.Entry) string {
+	if c, ok := entry.Data[LogCaller].(string); ok {
+		return c
+	}
+	return f.header()
+}
+
 func (f *DefaultLogFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if f.discard {
+		return nil, nil
+	}
 	for fieldK, fieldV := range f.Fields {
 		entry.Data[fieldK] = fieldV
 	}
+	for fieldK, fieldV := range FieldsFromContext(entry.Context) {
+		if _, ok := entry.Data[fieldK]; !ok { //显式传入的字段优先
+			entry.Data[fieldK] = fieldV
+		}
+	}
 	var keys = make([]string, 0, len(entry.Data))
 	var tag = LogTagUndef
 	for k := range entry.Data {
@@ -106,6 +171,9 @@ func (f *DefaultLogFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 			tag = entry.Data[k].(string)
 			continue
 		}
+		if k == LogCaller {
+			continue
+		}
 		keys = append(keys, k)
 	}
 
@@ -120,21 +188,25 @@ func (f *DefaultLogFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	if f.TimestampFormat == "" {
 		f.TimestampFormat = time.RFC3339
 	}
-	f.printLog(b, entry, keys, tag)
+	if f.Type == FormatTypeJson {
+		f.printJson(b, entry, keys, tag)
+	} else {
+		f.printLog(b, entry, keys, tag)
+	}
 
 	return b.Bytes(), nil
 }
 
 func (f *DefaultLogFormatter) printLog(b *bytes.Buffer, entry *logrus.Entry, keys []string, tag string) {
-	if f.DisableLog && tag != LogTagAccessIn &&
-		tag != LogTagAccessOut && entry.Logger.GetLevel() >= logrus.ErrorLevel {
+	if f.disabled(entry, tag) {
 		return
 	}
 	defer func() {
 		b.WriteByte('\n')
 	}()
 	levelText := strings.ToUpper(entry.Level.String())
-	header := f.header()
+	header := f.caller(entry)
+	trace, logid := f.entryTrace(entry)
 	if !f.FullTimestamp {
 		fmt.Fprintf(b, "[%s][%d][%s] %s||_msg=%s||logid=%d||traceid=%s",
 			levelText,
@@ -142,8 +214,8 @@ func (f *DefaultLogFormatter) printLog(b *bytes.Buffer, entry *logrus.Entry, key
 			header,
 			tag,
 			strings.Trim(entry.Message, " \r\t\v\n"),
-			f.WorkerId,
-			f.getTraceId())
+			logid,
+			trace.TraceId)
 	} else {
 		fmt.Fprintf(b, "[%s][%s][%s] %s||_msg=%s||logid=%d||traceid=%s",
 			levelText,
@@ -151,8 +223,11 @@ func (f *DefaultLogFormatter) printLog(b *bytes.Buffer, entry *logrus.Entry, key
 			header,
 			tag,
 			strings.Trim(entry.Message, " \r\t\v\n"),
-			f.WorkerId,
-			f.getTraceId())
+			logid,
+			trace.TraceId)
+	}
+	if len(trace.SpanId) > 0 {
+		fmt.Fprintf(b, "||spanid=%s", trace.SpanId)
 	}
 	for _, k := range keys {
 		v := entry.Data[k]
@@ -170,44 +245,99 @@ func (f *DefaultLogFormatter) printLog(b *bytes.Buffer, entry *logrus.Entry, key
 }
 
 func (f *DefaultLogFormatter) getTraceId() string {
-	if len(f.TraceId) <= 0 {
-		f.TraceId = calculateTraceId(getIp())
-	}
-	return f.TraceId
+	return f.getTrace().TraceId
 }
 
 func (f *DefaultLogFormatter) setTraceId(traceId string) {
+	f.traceMu.Lock()
+	defer f.traceMu.Unlock()
 	f.TraceId = traceId
 }
 
 func (f *DefaultLogFormatter) clearTrace() {
+	f.traceMu.Lock()
+	defer f.traceMu.Unlock()
 	f.Trace = Trace{}
 }
 
 func (f *DefaultLogFormatter) parseTrace(req *http.Request) {
-	f.TraceId = req.Header.Get(http.CanonicalHeaderKey(f.TraceHeader))
+	t, _ := f.extractTrace(req.Header)
+	if t == nil {
+		t = &Trace{}
+	}
+	f.traceMu.Lock()
+	defer f.traceMu.Unlock()
+	f.TraceId = t.TraceId
+	f.SpanId = t.SpanId
+	f.ParentSpanId = t.ParentSpanId
+	f.TraceFlags = t.TraceFlags
+	f.TraceState = t.TraceState
 }
 
+// parseTraceContext 将请求中的trace放入请求的context中，不修改formatter自身的trace
+func (f *DefaultLogFormatter) parseTraceContext(req *http.Request) context.Context {
+	t, ok := f.extractTrace(req.Header)
+	if !ok {
+		t = f.newTrace()
+	}
+	return ContextWithTrace(req.Context(), t)
+}
+
+// getTrace 返回formatter自身trace的副本，还没有trace时生成一条
 func (f *DefaultLogFormatter) getTrace() *Trace {
-	return &Trace{
-		TraceId: f.getTraceId(),
+	if f.traceOwner != nil {
+		return f.traceOwner.getTrace()
+	}
+	f.traceMu.RLock()
+	t := f.Trace
+	f.traceMu.RUnlock()
+	if len(t.TraceId) > 0 {
+		return &t
 	}
+	f.traceMu.Lock()
+	defer f.traceMu.Unlock()
+	if len(f.TraceId) <= 0 {
+		n := f.newTrace()
+		f.TraceId = n.TraceId
+		f.SpanId = n.SpanId
+		f.TraceFlags = n.TraceFlags
+	}
+	t = f.Trace
+	return &t
 }
+
 func (f *DefaultLogFormatter) setTrace(t *Trace) {
-	f.TraceId = t.TraceId
-	f.SrcMethod = t.SrcMethod
-	f.Caller = t.Caller
+	f.traceMu.Lock()
+	defer f.traceMu.Unlock()
+	f.Trace = *t
+}
+
+// contextTrace 优先使用ctx中的trace，没有时使用formatter自身的trace
+func (f *DefaultLogFormatter) contextTrace(ctx context.Context) *Trace {
+	if t, ok := TraceFromContext(ctx); ok {
+		return t
+	}
+	return f.getTrace()
+}
+
+// entryTrace 返回日志应输出的trace与logid
+func (f *DefaultLogFormatter) entryTrace(entry *logrus.Entry) (trace *Trace, logid int64) {
+	logid = f.WorkerId
+	if id, ok := LogidFromContext(entry.Context); ok {
+		logid = id
+	}
+	return f.contextTrace(entry.Context), logid
 }
 
-func (f *DefaultLogFormatter) addHttpTrace(req *http.Request) string {
-	trace := f.getTrace()
-	req.Header.Set(f.TraceHeader, trace.TraceId)
+func (f *DefaultLogFormatter) addHttpTrace(ctx context.Context, req *http.Request) string {
+	trace := f.contextTrace(ctx)
+	f.injectTrace(req.Header, trace)
 	return trace.TraceId
 }
 
-func (f *DefaultLogFormatter) addRspTrace(rsp *http.ResponseWriter) string {
-	trace := f.getTrace()
-	(*rsp).Header().Set(f.TraceHeader, trace.TraceId)
+func (f *DefaultLogFormatter) addRspTrace(ctx context.Context, rsp http.ResponseWriter) string {
+	trace := f.contextTrace(ctx)
+	f.injectTrace(rsp.Header(), trace)
 	return trace.TraceId
 }
 
@@ -0,0 +1,108 @@
+package hlog
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFormatterConfig(t *testing.T) {
+	entryTime := time.Date(2026, 10, 17, 12, 34, 56, 789000000, time.UTC)
+	newEntry := func(tag string) *logrus.Entry {
+		e := logrus.NewEntry(logrus.New())
+		e.Time = entryTime
+		e.Level = logrus.InfoLevel
+		e.Message = "hello"
+		e.Data = logrus.Fields{"b": 2, "a": 1}
+		if len(tag) > 0 {
+			e.Data[LogTag] = tag
+		}
+		return e
+	}
+	for _, c := range []struct {
+		name   string
+		format *FormatterConfig
+		tag    string
+		match  string //输出需要匹配的正则，为空表示不输出
+	}{
+		{"nil config uses full default timestamp", nil, "",
+			`^\[INFO\]\[2026-10-17 12:34:56\.789\+0000\]\[[^]]+\] _undef\|\|_msg=hello\|\|logid=1\|\|traceid=t1\|\|a=1\|\|b=2\n$`},
+		{"full timestamp with default format", &FormatterConfig{FullTimestamp: true}, "",
+			`^\[INFO\]\[2026-10-17 12:34:56\.789\+0000\]`},
+		{"relative miniTS timestamp", &FormatterConfig{FullTimestamp: false}, "",
+			`^\[INFO\]\[\d+\]\[`},
+		{"custom timestamp format", &FormatterConfig{FullTimestamp: true, TimestampFormat: "2006/01/02 15:04"}, "",
+			`^\[INFO\]\[2026/10/17 12:34\]`},
+		{"invalid timestamp format falls back", &FormatterConfig{FullTimestamp: true, TimestampFormat: "abc"}, "",
+			`^\[INFO\]\[2026-10-17 12:34:56\.789\+0000\]`},
+		{"sorted fields", &FormatterConfig{FullTimestamp: true}, "",
+			`\|\|a=1\|\|b=2\n$`},
+		{"unsorted fields", &FormatterConfig{FullTimestamp: true, DisableSorting: true}, "",
+			`(\|\|a=1\|\|b=2|\|\|b=2\|\|a=1)\n$`},
+		{"disable log drops ordinary lines", &FormatterConfig{DisableLog: true}, "", ""},
+		{"disable log keeps access logs", &FormatterConfig{DisableLog: true}, LogTagAccessIn,
+			` _com_request_in\|\|_msg=hello`},
+		{"disable log keeps failure tags", &FormatterConfig{DisableLog: true}, LogTagMysqlErr,
+			` _com_mysql_failure\|\|_msg=hello`},
+		{"disable log drops success tags", &FormatterConfig{DisableLog: true}, LogTagMysqlOk, ""},
+		{"unknown type falls back to text", &FormatterConfig{Type: "xml", FullTimestamp: true}, "",
+			`^\[INFO\]\[2026-10-17 12:34:56\.789\+0000\]`},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			f := NewDefaultLogFormatter(&Config{Format: c.format}, logrus.Fields{}, 1)
+			f.(*DefaultLogFormatter).setTraceId("t1")
+			b, err := f.Format(newEntry(c.tag))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(c.match) == 0 {
+				if len(b) > 0 {
+					t.Errorf("expected no output, got %q", b)
+				}
+				return
+			}
+			if !regexp.MustCompile(c.match).Match(b) {
+				t.Errorf("output %q does not match %s", b, c.match)
+			}
+		})
+	}
+}
+
+func TestJsonFormatterConfig(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		format *FormatterConfig
+		time   interface{} //nil表示相对时间，只检查是数字
+	}{
+		{"full timestamp", &FormatterConfig{Type: FormatTypeJson, FullTimestamp: true, TimestampFormat: time.RFC3339}, "2026-10-17T12:34:56Z"},
+		{"relative miniTS timestamp", &FormatterConfig{Type: FormatTypeJson}, nil},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			f := NewDefaultLogFormatter(&Config{Format: c.format}, logrus.Fields{}, 1)
+			e := logrus.NewEntry(logrus.New())
+			e.Time = time.Date(2026, 10, 17, 12, 34, 56, 0, time.UTC)
+			e.Message = "hello"
+			e.Data = logrus.Fields{"msg": "clash"}
+			b, err := f.Format(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m map[string]interface{}
+			if err := json.Unmarshal(b, &m); err != nil {
+				t.Fatalf("invalid json %q: %v", b, err)
+			}
+			if _, ok := m[jsonKeyTime].(float64); c.time == nil && !ok {
+				t.Errorf("time %v, want seconds since start", m[jsonKeyTime])
+			} else if c.time != nil && m[jsonKeyTime] != c.time {
+				t.Errorf("time %v, want %v", m[jsonKeyTime], c.time)
+			}
+			if m[jsonKeyMsg] != "hello" || m["fields.msg"] != "clash" {
+				t.Errorf("unexpected msg fields in %s", strings.TrimSpace(string(b)))
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package hlog
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"runtime"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HttpMiddleware wraps next so that every request is logged with LogTagAccessIn on arrival
+// and LogTagAccessOut with status, response bytes and proc_time when the handler returns.
+// The trace is parsed from the request headers (or generated), echoed on the response, and
+// carried by the request context, so handlers log through EntryFromContext(req.Context()).
+// The access logs report next's name as their caller, since they are logged from inside hlog.
+func (l *Logger) HttpMiddleware(next http.Handler) http.Handler {
+	caller := handlerName(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := ContextWithLogger(l.ParseTraceContext(req), l)
+		req = req.WithContext(ctx)
+		begin := time.Now()
+		fields := logrus.Fields{
+			"method":      req.Method,
+			"uri":         req.RequestURI,
+			"host":        req.Host,
+			"remote_addr": req.RemoteAddr,
+			LogCaller:     caller,
+		}
+		l.WithContext(ctx).WithFields(GetLogField(LogTagAccessIn, fields)).Info()
+
+		rw := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		l.AddRspTraceContext(ctx, rw)
+		defer func() {
+			p := recover()
+			if p != nil && !rw.wroteHeader {
+				rw.status = http.StatusInternalServerError
+			}
+			out := GetLogField(LogTagAccessOut, fields, logrus.Fields{
+				LogBegin:    begin,
+				"status":    rw.status,
+				"rsp_bytes": rw.bytes,
+			})
+			entry := l.WithContext(ctx).WithFields(out)
+			if p != nil || rw.status >= http.StatusInternalServerError {
+				entry.Error()
+			} else {
+				entry.Info()
+			}
+			if p != nil {
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// handlerName 返回handler的函数名或类型名，用作access日志的调用位置
+func handlerName(h http.Handler) string {
+	if f, ok := h.(http.HandlerFunc); ok {
+		if fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer()); fn != nil {
+			return fn.Name()
+		}
+	}
+	return reflect.TypeOf(h).String()
+}
+
+// responseRecorder 记录handler写出的状态码与响应大小
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		r.wroteHeader = true
+		f.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := r.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("hijack not supported")
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
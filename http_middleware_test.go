@@ -0,0 +1,81 @@
+package hlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+type syncBuffer struct {
+	mu sync.Mutex
+	bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.Buffer.Write(p)
+}
+
+// jsonLines 按行解析json格式的日志
+func (b *syncBuffer) jsonLines(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("invalid json line %q: %v", line, err)
+		}
+		lines = append(lines, m)
+	}
+	return lines
+}
+
+// newTestLogger 返回输出json格式日志到buf的Logger
+func newTestLogger(buf *syncBuffer) *Logger {
+	c := &Config{level: logrus.DebugLevel, Format: &FormatterConfig{Type: FormatTypeJson, FullTimestamp: true}}
+	return newLogger(c, buf, 1)
+}
+
+func testHandler(w http.ResponseWriter, req *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+func TestHttpMiddlewareCaller(t *testing.T) {
+	buf := &syncBuffer{}
+	l := newTestLogger(buf)
+	srv := httptest.NewServer(l.HttpMiddleware(http.HandlerFunc(testHandler)))
+	defer srv.Close()
+	rsp, err := http.Get(srv.URL + "/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+
+	lines := buf.jsonLines(t)
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2", len(lines))
+	}
+	for i, tag := range []string{LogTagAccessIn, LogTagAccessOut} {
+		if lines[i][jsonKeyTag] != tag {
+			t.Errorf("line %d tag %v, want %s", i, lines[i][jsonKeyTag], tag)
+		}
+		if lines[i][jsonKeyCaller] != "github.com/tmsong/hlog.testHandler" {
+			t.Errorf("line %d caller %v, want the handler name", i, lines[i][jsonKeyCaller])
+		}
+		if _, ok := lines[i][LogCaller]; ok {
+			t.Errorf("line %d leaks the %s field", i, LogCaller)
+		}
+	}
+	if name := handlerName(http.NewServeMux()); name != "*http.ServeMux" {
+		t.Errorf("handler name %s, want *http.ServeMux", name)
+	}
+}
@@ -0,0 +1,122 @@
+package hlog
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const redactedValue = "***"
+
+// HttpTransport is an http.RoundTripper that injects the trace into outgoing requests and
+// logs every call with LogTagRequestOk or LogTagRequestErr, the method, url, status and
+// proc_time. The trace is taken from the request context when present, see ContextWithTrace.
+// The logs carry no caller, since they are written from inside net/http's client.
+type HttpTransport struct {
+	Base   http.RoundTripper //实际发送请求的RoundTripper，默认为http.DefaultTransport
+	Logger *Logger
+	//判断请求是否成功，决定使用哪个tag，默认为没有error且状态码小于500
+	IsSuccess func(rsp *http.Response, err error) bool
+	//记录日志前改写url中的query，如RedactQueryKeys("token")
+	RedactQuery func(query url.Values) url.Values
+	//需要记录的请求header
+	LogHeaders []string
+	//记录日志前改写header的值，如RedactHeaderKeys("Authorization")
+	RedactHeader func(key, value string) string
+}
+
+// NewHttpTransport returns an HttpTransport logging through l and sending through base
+func (l *Logger) NewHttpTransport(base http.RoundTripper) *HttpTransport {
+	return &HttpTransport{Base: base, Logger: l}
+}
+
+// NewHttpClient returns an http.Client whose transport logs through l
+func (l *Logger) NewHttpClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: l.NewHttpTransport(nil), Timeout: timeout}
+}
+
+func (t *HttpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	req = req.Clone(ctx) //RoundTripper不应修改调用方的请求
+	t.Logger.AddHttpTraceContext(ctx, req)
+	rec := t.Logger.StartHttp(ctx, logrus.Fields{
+		"method":  req.Method,
+		"url":     t.logURL(req.URL),
+		LogCaller: "", //日志在net/http的client中记录，调用位置没有意义
+	})
+	if len(t.LogHeaders) > 0 {
+		headers := make(map[string]string, len(t.LogHeaders))
+		for _, k := range t.LogHeaders {
+			if v := req.Header.Get(k); len(v) > 0 {
+				if t.RedactHeader != nil {
+					v = t.RedactHeader(k, v)
+				}
+				headers[k] = v
+			}
+		}
+		if len(headers) > 0 {
+			rec.AddFields(logrus.Fields{"headers": headers})
+		}
+	}
+	rsp, err := t.base().RoundTrip(req)
+
+	var code interface{}
+	if rsp != nil {
+		rec.AddFields(logrus.Fields{"status": rsp.StatusCode})
+	} else if err != nil {
+		code, _ = ErrorCode(err)
+	}
+	rec.finish(t.isSuccess(rsp, err), code, err)
+	return rsp, err
+}
+
+func (t *HttpTransport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+	return t.Base
+}
+
+func (t *HttpTransport) isSuccess(rsp *http.Response, err error) bool {
+	if t.IsSuccess != nil {
+		return t.IsSuccess(rsp, err)
+	}
+	return err == nil && rsp != nil && rsp.StatusCode < http.StatusInternalServerError
+}
+
+// logURL 返回记录到日志中的url，userinfo中的密码总是被隐去
+func (t *HttpTransport) logURL(u *url.URL) string {
+	redacted := *u
+	if t.RedactQuery != nil && len(u.RawQuery) > 0 {
+		redacted.RawQuery = t.RedactQuery(u.Query()).Encode()
+	}
+	return redacted.Redacted()
+}
+
+// RedactQueryKeys returns a RedactQuery hook that masks the values of the given query keys
+func RedactQueryKeys(keys ...string) func(url.Values) url.Values {
+	return func(query url.Values) url.Values {
+		for _, k := range keys {
+			if _, ok := query[k]; ok {
+				query.Set(k, redactedValue)
+			}
+		}
+		return query
+	}
+}
+
+// RedactHeaderKeys returns a RedactHeader hook that masks the values of the given headers
+func RedactHeaderKeys(keys ...string) func(key, value string) string {
+	redact := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		redact[http.CanonicalHeaderKey(k)] = true
+	}
+	return func(key, value string) string {
+		if redact[http.CanonicalHeaderKey(key)] {
+			return redactedValue
+		}
+		return value
+	}
+}
@@ -0,0 +1,47 @@
+package hlog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHttpTransportLog(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(testHandler))
+	defer srv.Close()
+	buf := &syncBuffer{}
+	l := newTestLogger(buf)
+	tr := l.NewHttpTransport(nil)
+	tr.RedactQuery = RedactQueryKeys("token")
+	client := &http.Client{Transport: tr}
+
+	u := strings.Replace(srv.URL, "http://", "http://user:secret@", 1) + "/?token=abc&q=1"
+	rsp, err := client.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+
+	lines := buf.jsonLines(t)
+	if len(lines) != 1 {
+		t.Fatalf("got %d log lines, want 1", len(lines))
+	}
+	line := lines[0]
+	if line[jsonKeyTag] != LogTagRequestOk {
+		t.Errorf("tag %v, want %s", line[jsonKeyTag], LogTagRequestOk)
+	}
+	logged, _ := line["url"].(string)
+	if strings.Contains(logged, "secret") || strings.Contains(logged, "abc") {
+		t.Errorf("url %q leaks the password or the token", logged)
+	}
+	if !strings.Contains(logged, "q=1") || !strings.Contains(logged, "user:") {
+		t.Errorf("url %q lost the user or the unredacted query", logged)
+	}
+	if _, ok := line[jsonKeyCaller]; ok {
+		t.Errorf("transport log has caller %v", line[jsonKeyCaller])
+	}
+	if line["status"] != float64(http.StatusOK) {
+		t.Errorf("status %v, want 200", line["status"])
+	}
+}
@@ -0,0 +1,101 @@
+package hlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 输出格式，通过FormatterConfig.Type选择
+const (
+	FormatTypeText = "text"
+	FormatTypeJson = "json"
+)
+
+// json格式中固定输出的顶层字段，与其同名的自定义字段会加上fields.前缀
+const (
+	jsonKeyLevel    = "level"
+	jsonKeyTime     = "time"
+	jsonKeyCaller   = "caller"
+	jsonKeyTag      = "tag"
+	jsonKeyMsg      = "msg"
+	jsonKeyLogid    = "logid"
+	jsonKeyTraceid  = "traceid"
+	jsonKeySpanid   = "spanid"
+	jsonKeyProcTime = "proc_time"
+)
+
+var jsonReservedKeys = map[string]bool{
+	jsonKeyLevel:    true,
+	jsonKeyTime:     true,
+	jsonKeyCaller:   true,
+	jsonKeyTag:      true,
+	jsonKeyMsg:      true,
+	jsonKeyLogid:    true,
+	jsonKeyTraceid:  true,
+	jsonKeySpanid:   true,
+	jsonKeyProcTime: true,
+}
+
+func (f *DefaultLogFormatter) printJson(b *bytes.Buffer, entry *logrus.Entry, keys []string, tag string) {
+	if f.disabled(entry, tag) {
+		return
+	}
+	m := make(map[string]interface{}, len(keys)+8)
+	for _, k := range keys {
+		v := entry.Data[k]
+		if k == LogBegin {
+			if begin, ok := v.(time.Time); ok {
+				m[jsonKeyProcTime] = float64(entry.Time.Sub(begin).Nanoseconds()) / (1000 * 1000)
+				continue
+			}
+		}
+		if jsonReservedKeys[k] {
+			k = "fields." + k
+		}
+		switch t := v.(type) {
+		case []byte:
+			v = string(t)
+		case error:
+			v = t.Error()
+		}
+		m[k] = v
+	}
+	m[jsonKeyLevel] = strings.ToUpper(entry.Level.String())
+	if f.FullTimestamp {
+		m[jsonKeyTime] = entry.Time.Format(f.TimestampFormat)
+	} else {
+		m[jsonKeyTime] = miniTS()
+	}
+	if caller := f.caller(entry); len(caller) > 0 {
+		m[jsonKeyCaller] = caller
+	}
+	m[jsonKeyTag] = tag
+	m[jsonKeyMsg] = strings.Trim(entry.Message, " \r\t\v\n")
+	trace, logid := f.entryTrace(entry)
+	m[jsonKeyLogid] = logid
+	m[jsonKeyTraceid] = trace.TraceId
+	if len(trace.SpanId) > 0 {
+		m[jsonKeySpanid] = trace.SpanId
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil { //存在无法序列化的字段时，将这些字段退化为字符串
+		for k, v := range m {
+			if _, err := json.Marshal(v); err != nil {
+				m[k] = fmt.Sprintf("%v", v)
+			}
+		}
+		if data, err = json.Marshal(m); err != nil {
+			fmt.Fprintf(b, `{"level":"ERROR","msg":%q}`, err.Error())
+			b.WriteByte('\n')
+			return
+		}
+	}
+	b.Write(data)
+	b.WriteByte('\n')
+}
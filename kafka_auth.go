@@ -0,0 +1,100 @@
+package hlog
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// KafkaSASLConfig.Mechanism的取值
+const (
+	KafkaSASLPlain       = "PLAIN"
+	KafkaSASLScramSHA256 = "SCRAM-SHA-256"
+	KafkaSASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// tlsConfig 根据配置生成*tls.Config，未开启时返回nil
+func (c *KafkaTLSConfig) tlsConfig() (*tls.Config, error) {
+	if c == nil || !c.Enable {
+		return nil, nil
+	}
+	tc := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		ServerName:         c.ServerName,
+	}
+	if len(c.CAFile) > 0 {
+		ca, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in kafka ca file %s", c.CAFile)
+		}
+	}
+	if len(c.CertFile) > 0 || len(c.KeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// apply 将SASL配置写入producer的配置
+func (c *KafkaSASLConfig) apply(kc *sarama.Config) error {
+	if c == nil {
+		return nil
+	}
+	kc.Net.SASL.Enable = true
+	kc.Net.SASL.Handshake = true
+	kc.Net.SASL.User = c.User
+	kc.Net.SASL.Password = c.Password
+	switch c.Mechanism {
+	case "", KafkaSASLPlain:
+		kc.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case KafkaSASLScramSHA256:
+		kc.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		kc.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{HashGeneratorFcn: sha256.New}
+		}
+	case KafkaSASLScramSHA512:
+		kc.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		kc.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{HashGeneratorFcn: sha512.New}
+		}
+	default:
+		return fmt.Errorf("unknown kafka sasl mechanism %q", c.Mechanism)
+	}
+	return nil
+}
+
+// scramClient 实现sarama.SCRAMClient
+type scramClient struct {
+	scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(user, password, authzID string) error {
+	client, err := c.HashGeneratorFcn.NewClient(user, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
@@ -0,0 +1,140 @@
+package hlog
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// KafkaConfig.RequiredAcks的取值
+const (
+	KafkaAcksNone   = "none"   //不等待broker确认，默认
+	KafkaAcksLeader = "leader" //等待leader写入
+	KafkaAcksAll    = "all"    //等待所有同步副本写入
+)
+
+// KafkaConfig.PartitionKey的取值
+const (
+	KafkaPartitionByTime     = "time"        //按日志时间，默认，同一请求的日志会分散到不同分区
+	KafkaPartitionByTraceId  = "trace_id"    //按trace id，同一trace的日志落在同一分区并保持顺序
+	KafkaPartitionByField    = "field"       //按PartitionField指定的字段，字段不存在时随机分区
+	KafkaPartitionByHostname = "hostname"    //按机器名
+	KafkaPartitionRoundRobin = "round_robin" //轮流写入各个分区
+	KafkaPartitionNone       = "none"        //不设置key，随机分区
+)
+
+// KafkaConfig.Headers中的内置消息头，其他名字取entry中的同名字段
+const (
+	KafkaHeaderLevel    = "level"
+	KafkaHeaderTag      = "tag"
+	KafkaHeaderTraceId  = "trace_id"
+	KafkaHeaderApp      = "app"
+	KafkaHeaderAppName  = "app_name"
+	KafkaHeaderEnv      = "env"
+	KafkaHeaderHostname = "hostname"
+)
+
+const (
+	defaultKafkaCompression    = sarama.CompressionSnappy
+	defaultKafkaFlushFrequency = 500 * time.Millisecond
+)
+
+// saramaConfig 根据KafkaConfig生成producer的配置
+func (c *KafkaConfig) saramaConfig() (*sarama.Config, error) {
+	kc := sarama.NewConfig()
+	switch c.RequiredAcks {
+	case "", KafkaAcksNone:
+		kc.Producer.RequiredAcks = sarama.NoResponse
+	case KafkaAcksLeader:
+		kc.Producer.RequiredAcks = sarama.WaitForLocal
+	case KafkaAcksAll:
+		kc.Producer.RequiredAcks = sarama.WaitForAll
+	default:
+		return nil, fmt.Errorf("unknown kafka required acks %q", c.RequiredAcks)
+	}
+
+	kc.Producer.Compression = defaultKafkaCompression
+	if len(c.Compression) > 0 {
+		if err := kc.Producer.Compression.UnmarshalText([]byte(c.Compression)); err != nil {
+			return nil, err
+		}
+	}
+
+	kc.Producer.Flush.Frequency = defaultKafkaFlushFrequency
+	if c.FlushFrequency > 0 {
+		kc.Producer.Flush.Frequency = time.Duration(c.FlushFrequency) * time.Millisecond
+	}
+	if c.FlushMessages > 0 {
+		kc.Producer.Flush.Messages = c.FlushMessages
+	}
+	if c.FlushBytes > 0 {
+		kc.Producer.Flush.Bytes = c.FlushBytes
+	}
+	if c.MaxRetries > 0 {
+		kc.Producer.Retry.Max = c.MaxRetries
+	} else if c.MaxRetries < 0 {
+		kc.Producer.Retry.Max = 0
+	}
+	if c.RetryBackoff > 0 {
+		kc.Producer.Retry.Backoff = time.Duration(c.RetryBackoff) * time.Millisecond
+	}
+
+	if len(c.Version) > 0 {
+		version, err := sarama.ParseKafkaVersion(c.Version)
+		if err != nil {
+			return nil, err
+		}
+		kc.Version = version
+	}
+	//消息头需要kafka 0.11及以上，未配置Version时sarama的默认版本已满足
+	if len(c.Headers) > 0 && len(c.Version) > 0 && !kc.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return nil, fmt.Errorf("kafka headers need version 0.11 or later, got %s", c.Version)
+	}
+
+	if c.Idempotent {
+		if len(c.RequiredAcks) == 0 {
+			kc.Producer.RequiredAcks = sarama.WaitForAll
+		}
+		if kc.Producer.Retry.Max == 0 {
+			return nil, fmt.Errorf("kafka idempotent producer needs retries")
+		}
+		kc.Producer.Idempotent = true
+		kc.Net.MaxOpenRequests = 1
+	}
+
+	switch c.PartitionKey {
+	case "", KafkaPartitionByTime, KafkaPartitionByTraceId, KafkaPartitionByHostname, KafkaPartitionNone:
+	case KafkaPartitionByField:
+		if len(c.PartitionField) == 0 {
+			return nil, fmt.Errorf("kafka partition key %s needs PartitionField", c.PartitionKey)
+		}
+	case KafkaPartitionRoundRobin:
+		kc.Producer.Partitioner = sarama.NewRoundRobinPartitioner
+	default:
+		return nil, fmt.Errorf("unknown kafka partition key %q", c.PartitionKey)
+	}
+
+	tc, err := c.TLS.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tc != nil {
+		kc.Net.TLS.Enable = true
+		kc.Net.TLS.Config = tc
+	}
+	if err = c.SASL.apply(kc); err != nil {
+		return nil, err
+	}
+
+	kc.Producer.Return.Successes = true //用于统计还未投递完成的消息，关闭时等待它们投递完成
+	kc.Producer.Return.Errors = true
+	return kc, kc.Validate()
+}
+
+func (c *KafkaConfig) closeTimeout() time.Duration {
+	if c.CloseTimeout <= 0 {
+		return defaultKafkaCloseTimeout
+	}
+	return time.Duration(c.CloseTimeout) * time.Millisecond
+}
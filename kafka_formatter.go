@@ -52,7 +52,8 @@ func (f *DefaultKafkaLogFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 		"message":    string(message),
 	}
 	if defaultf, ok := f.Formatter.(*DefaultLogFormatter); ok {
-		m["trace_id"] = defaultf.TraceId
+		trace, _ := defaultf.entryTrace(entry)
+		m["trace_id"] = trace.TraceId
 	}
 	return json.Marshal(m)
 }
@@ -2,12 +2,11 @@ package hlog
 
 import (
 	"crypto/tls"
-	"errors"
+	"fmt"
 	"github.com/IBM/sarama"
 	"github.com/sirupsen/logrus"
-	"log"
 	"os"
-	"time"
+	"strings"
 )
 
 func NewKafkaHookWithFormatter(f logrus.Formatter, c *KafkaConfig, level logrus.Level) (*KafkaLogrusHook, error) {
@@ -27,7 +26,9 @@ type KafkaLogrusHook struct {
 	hostname  string
 	levels    []logrus.Level
 	formatter logrus.Formatter
-	producer  sarama.AsyncProducer
+	producer  *kafkaProducer
+	routes    []kafkaRoute
+	owner     bool //Clone出的hook与原hook共享producer，只有原hook负责关闭
 }
 
 // NewKafkaLogrusHook creates a new KafkaHook
@@ -38,12 +39,13 @@ func NewKafkaLogrusHook(
 	tls *tls.Config) (*KafkaLogrusHook, error) {
 	var err error
 	var producer sarama.AsyncProducer
-	kafkaConfig := sarama.NewConfig()
-	kafkaConfig.Producer.RequiredAcks = sarama.NoResponse         // No wait
-	kafkaConfig.Producer.Compression = sarama.CompressionSnappy   // Compress messages
-	kafkaConfig.Producer.Flush.Frequency = 500 * time.Millisecond // Flush batches every 500ms
+	var kafkaConfig *sarama.Config
+	if kafkaConfig, err = c.saramaConfig(); err != nil {
+		return nil, err
+	}
 
-	// check here if provided *tls.Config is not nil and assign to the sarama config
+	// check here if provided *tls.Config is not nil and assign to the sarama config,
+	// it takes precedence over c.TLS
 	// NOTE: we automatically enabled the TLS config because sarama would error out if our
 	//       config were non-nil but disabled. To avoid issue further down the stack, we enable.
 	if tls != nil {
@@ -54,12 +56,25 @@ func NewKafkaLogrusHook(
 	if producer, err = sarama.NewAsyncProducer(c.Servers, kafkaConfig); err != nil {
 		return nil, err
 	}
+	return newKafkaLogrusHook(levels, formatter, c, producer)
+}
 
-	go func() {
-		for err := range producer.Errors() {
-			log.Printf("Failed to send log entry to Kafka: %v\n", err)
-		}
-	}()
+// newKafkaLogrusHook creates a KafkaHook on an existing producer, such as sarama's mocks
+func newKafkaLogrusHook(
+	levels []logrus.Level,
+	formatter logrus.Formatter,
+	c *KafkaConfig,
+	producer sarama.AsyncProducer) (*KafkaLogrusHook, error) {
+	routes, err := compileKafkaRoutes(c.Routes)
+	if err != nil {
+		producer.AsyncClose()
+		return nil, err
+	}
+	p, err := newKafkaProducer(c, producer)
+	if err != nil {
+		producer.AsyncClose()
+		return nil, err
+	}
 
 	var hostname string
 	if hostname, err = os.Hostname(); err != nil {
@@ -71,7 +86,9 @@ func NewKafkaLogrusHook(
 		hostname,
 		levels,
 		formatter,
-		producer,
+		p,
+		routes,
+		true,
 	}
 
 	return hook, nil
@@ -84,6 +101,8 @@ func (hook *KafkaLogrusHook) Clone(f logrus.Formatter) *KafkaLogrusHook {
 		hook.levels,
 		KafkaFormatter(f, hook.config),
 		hook.producer,
+		hook.routes,
+		false,
 	}
 }
 
@@ -94,16 +113,9 @@ func (hook *KafkaLogrusHook) Levels() []logrus.Level {
 
 // Fire is required to implement the hook interface from logrus
 func (hook *KafkaLogrusHook) Fire(entry *logrus.Entry) error {
-	var partitionKey sarama.ByteEncoder
 	var b []byte
 	var err error
 
-	t := entry.Time
-	if b, err = t.MarshalBinary(); err != nil {
-		return err
-	}
-	partitionKey = sarama.ByteEncoder(b)
-
 	if hook.config.InjectHostname {
 		if _, ok := entry.Data["hostname"]; !ok {
 			entry.Data["hostname"] = hook.hostname
@@ -115,18 +127,112 @@ func (hook *KafkaLogrusHook) Fire(entry *logrus.Entry) error {
 	}
 	value := sarama.ByteEncoder(b)
 
-	topic := hook.config.Topic
-	if tsRaw, ok := entry.Data["topic"]; ok {
-		if ts, ok := tsRaw.(string); !ok {
-			return errors.New("Incorrect topic filed type (should be string)")
-		} else {
-			topic = ts
-		}
+	var partitionKey sarama.Encoder
+	if partitionKey, err = hook.partitionKey(entry); err != nil {
+		return err
+	}
+
+	hook.producer.enqueue(&sarama.ProducerMessage{
+		Key:     partitionKey,
+		Topic:   hook.topic(entry),
+		Value:   value,
+		Headers: hook.headers(entry),
+	})
+	return nil
+}
+
+// Stats returns the delivery counters shared by the hook and its clones
+func (hook *KafkaLogrusHook) Stats() KafkaHookStats {
+	return hook.producer.stats()
+}
+
+// Close flushes buffered messages and closes the producer, waiting at most KafkaConfig.CloseTimeout,
+// it is a no-op for hooks created by Clone since they share the original hook's producer.
+// The error counts every message that was dropped, failed without being spooled, or was still
+// in flight at the deadline, see Stats for the breakdown.
+func (hook *KafkaLogrusHook) Close() error {
+	if !hook.owner {
+		return nil
 	}
-	hook.producer.Input() <- &sarama.ProducerMessage{
-		Key:   partitionKey,
-		Topic: topic,
-		Value: value,
+	if n := hook.producer.close(hook.config.closeTimeout()); n > 0 {
+		return fmt.Errorf("kafka hook closed with %d messages undelivered", n)
 	}
 	return nil
 }
+
+// partitionKey 按照配置的方式生成消息的分区key，返回nil时由partitioner随机或轮流选择分区
+func (hook *KafkaLogrusHook) partitionKey(entry *logrus.Entry) (sarama.Encoder, error) {
+	var key string
+	switch hook.config.PartitionKey {
+	case "", KafkaPartitionByTime:
+		b, err := entry.Time.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		return sarama.ByteEncoder(b), nil
+	case KafkaPartitionByTraceId:
+		key = hook.traceId(entry)
+	case KafkaPartitionByField:
+		if v, ok := entry.Data[hook.config.PartitionField]; ok {
+			key = fmt.Sprintf("%v", v)
+		}
+	case KafkaPartitionByHostname:
+		key = hook.hostname
+	}
+	if len(key) == 0 {
+		return nil, nil
+	}
+	return sarama.StringEncoder(key), nil
+}
+
+// traceId 返回entry所属的trace id，优先使用context中的trace
+func (hook *KafkaLogrusHook) traceId(entry *logrus.Entry) string {
+	if kf, ok := hook.formatter.(*DefaultKafkaLogFormatter); ok {
+		if df, ok := kf.Formatter.(*DefaultLogFormatter); ok {
+			trace, _ := df.entryTrace(entry)
+			return trace.TraceId
+		}
+	}
+	if trace, ok := TraceFromContext(entry.Context); ok {
+		return trace.TraceId
+	}
+	return ""
+}
+
+// headers 按照KafkaConfig.Headers生成消息头，值为空的头不添加
+func (hook *KafkaLogrusHook) headers(entry *logrus.Entry) []sarama.RecordHeader {
+	if len(hook.config.Headers) == 0 {
+		return nil
+	}
+	headers := make([]sarama.RecordHeader, 0, len(hook.config.Headers))
+	for _, name := range hook.config.Headers {
+		var value string
+		switch name {
+		case KafkaHeaderLevel:
+			value = strings.ToUpper(entry.Level.String())
+		case KafkaHeaderTag:
+			value = LogTagUndef
+			if t, ok := entry.Data[LogTag].(string); ok {
+				value = t
+			}
+		case KafkaHeaderTraceId:
+			value = hook.traceId(entry)
+		case KafkaHeaderApp:
+			value = hook.config.App
+		case KafkaHeaderAppName:
+			value = hook.config.AppName
+		case KafkaHeaderEnv:
+			value = hook.config.EnvName
+		case KafkaHeaderHostname:
+			value = hook.hostname
+		default:
+			if v, ok := entry.Data[name]; ok {
+				value = fmt.Sprintf("%v", v)
+			}
+		}
+		if len(value) > 0 {
+			headers = append(headers, sarama.RecordHeader{Key: []byte(name), Value: []byte(value)})
+		}
+	}
+	return headers
+}
@@ -0,0 +1,364 @@
+package hlog
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/sirupsen/logrus"
+)
+
+// newMockKafkaHook 创建一个使用sarama mocks producer的hook，producer的配置与线上一致
+func newMockKafkaHook(t *testing.T, c *KafkaConfig) (*KafkaLogrusHook, *mocks.AsyncProducer) {
+	t.Helper()
+	kc, err := c.saramaConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp := mocks.NewAsyncProducer(t, kc)
+	f := NewDefaultLogFormatter(&Config{}, logrus.Fields{}, 1)
+	hook, err := newKafkaLogrusHook(logrus.AllLevels, KafkaFormatter(f, c), c, mp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hook, mp
+}
+
+func newKafkaEntry(msg string, fields logrus.Fields) *logrus.Entry {
+	e := logrus.NewEntry(logrus.New()).WithFields(fields)
+	e.Time = time.Now()
+	e.Level = logrus.InfoLevel
+	e.Message = msg
+	return e
+}
+
+// waitFor 等待cond成立，超时后失败
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// fakeProducer 是可以控制何时接收消息的AsyncProducer
+type fakeProducer struct {
+	sarama.AsyncProducer
+	input     chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+	successes chan *sarama.ProducerMessage
+}
+
+func newFakeProducer() *fakeProducer {
+	return &fakeProducer{
+		input:     make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+		successes: make(chan *sarama.ProducerMessage),
+	}
+}
+
+func (p *fakeProducer) Input() chan<- *sarama.ProducerMessage     { return p.input }
+func (p *fakeProducer) Errors() <-chan *sarama.ProducerError      { return p.errors }
+func (p *fakeProducer) Successes() <-chan *sarama.ProducerMessage { return p.successes }
+func (p *fakeProducer) AsyncClose() {
+	close(p.errors)
+	close(p.successes)
+}
+
+func TestKafkaSpoolOnError(t *testing.T) {
+	dir := t.TempDir()
+	hook, mp := newMockKafkaHook(t, &KafkaConfig{Topic: "logs", SpoolDir: dir})
+	mp.ExpectInputAndFail(sarama.ErrOutOfBrokers)
+	if err := hook.Fire(newKafkaEntry("lost", nil)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool { return hook.Stats().Spooled == 1 })
+	if err := hook.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	spool, err := openKafkaSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, msgs, err := spool.oldest()
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("spool holds %d messages, err %v", len(msgs), err)
+	}
+	value, _ := msgs[0].Value.Encode()
+	if msgs[0].Topic != "logs" || !strings.Contains(string(value), "lost") {
+		t.Errorf("unexpected spooled message %s: %s", msgs[0].Topic, value)
+	}
+}
+
+func TestKafkaSpoolReplayOrder(t *testing.T) {
+	const n = 20
+	dir := t.TempDir()
+	spool, err := openKafkaSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ { //上次进程退出时未重放的消息
+		if err := spool.append(&sarama.ProducerMessage{Topic: "logs", Value: sarama.StringEncoder(fmt.Sprintf("old-%d", i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spool.close()
+
+	hook, mp := newMockKafkaHook(t, &KafkaConfig{Topic: "logs", SpoolDir: dir})
+	var mu sync.Mutex
+	var got []string
+	for i := 0; i <= n; i++ {
+		mp.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			value, _ := msg.Value.Encode()
+			mu.Lock()
+			got = append(got, string(value))
+			mu.Unlock()
+			return nil
+		})
+	}
+	//spool中还有消息时，新消息排在它们之后
+	if err := hook.Fire(newKafkaEntry("new", nil)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool { return hook.Stats().Replayed == n+1 })
+	if err := hook.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != n+1 {
+		t.Fatalf("producer got %d messages, want %d", len(got), n+1)
+	}
+	for i := 0; i < n; i++ {
+		if got[i] != fmt.Sprintf("old-%d", i) {
+			t.Fatalf("message %d is %q, want old-%d", i, got[i], i)
+		}
+	}
+	if !strings.Contains(got[n], `"message":"`) || !strings.Contains(got[n], "new") {
+		t.Errorf("last message is %q, want the new entry", got[n])
+	}
+	if reopened, _ := openKafkaSpool(dir, 0); reopened.pending() {
+		t.Error("replayed segments were not removed")
+	}
+}
+
+func TestKafkaSpoolMaxSize(t *testing.T) {
+	spool, err := openKafkaSpool(t.TempDir(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.close()
+	p := &kafkaProducer{config: &KafkaConfig{}, spool: spool}
+	value := sarama.ByteEncoder(make([]byte, 300*1024)) //base64后约400KB
+	for i := 0; i < 4; i++ {
+		p.spoolMessage(&sarama.ProducerMessage{Topic: "logs", Value: value})
+	}
+	if stats := p.stats(); stats.Spooled != 2 || stats.Dropped != 2 {
+		t.Errorf("unexpected stats %+v with a 1MB spool", stats)
+	}
+	if err := spool.append(&sarama.ProducerMessage{Topic: "logs", Value: value}); err != errKafkaSpoolFull {
+		t.Errorf("append to a full spool returned %v", err)
+	}
+}
+
+func TestKafkaSpoolBusyProducer(t *testing.T) {
+	fp := newFakeProducer()
+	go func() { //producer短暂忙碌，但一直在接收消息
+		for msg := range fp.input {
+			time.Sleep(20 * time.Millisecond)
+			fp.successes <- msg
+		}
+	}()
+	c := &KafkaConfig{Topic: "logs", SpoolDir: t.TempDir()}
+	hook, err := newKafkaLogrusHook(logrus.AllLevels, &logrus.JSONFormatter{}, c, fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		hook.Fire(newKafkaEntry("busy", nil))
+	}
+	waitFor(t, 2*time.Second, func() bool { return hook.producer.inflight.Load() == 0 && len(hook.producer.buffer) == 0 })
+	if stats := hook.Stats(); stats.Spooled != 0 {
+		t.Errorf("a briefly busy producer caused spooling: %+v", stats)
+	}
+	close(fp.input)
+	if err := hook.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKafkaHeaders(t *testing.T) {
+	c := &KafkaConfig{
+		Topic:   "logs",
+		App:     "app",
+		AppName: "name",
+		EnvName: "prod",
+		Headers: []string{KafkaHeaderLevel, KafkaHeaderTag, KafkaHeaderTraceId, KafkaHeaderApp,
+			KafkaHeaderAppName, KafkaHeaderEnv, KafkaHeaderHostname, "uid", "missing"},
+	}
+	hook, mp := newMockKafkaHook(t, c)
+	headers := make(chan []sarama.RecordHeader, 1)
+	mp.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		headers <- msg.Headers
+		return nil
+	})
+	entry := newKafkaEntry("hello", logrus.Fields{LogTag: LogTagMysqlErr, "uid": 42})
+	entry.Level = logrus.ErrorLevel
+	entry.Context = ContextWithTrace(context.Background(), &Trace{TraceId: "trace-1"})
+	if err := hook.Fire(entry); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, h := range <-headers {
+		got[string(h.Key)] = string(h.Value)
+	}
+	want := map[string]string{
+		KafkaHeaderLevel:    "ERROR",
+		KafkaHeaderTag:      LogTagMysqlErr,
+		KafkaHeaderTraceId:  "trace-1",
+		KafkaHeaderApp:      "app",
+		KafkaHeaderAppName:  "name",
+		KafkaHeaderEnv:      "prod",
+		KafkaHeaderHostname: hook.hostname,
+		"uid":               "42",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("headers %v, want %v", got, want)
+	}
+	if err := hook.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKafkaHeadersVersion(t *testing.T) {
+	kc, err := (&KafkaConfig{Headers: []string{KafkaHeaderLevel}, Compression: "zstd"}).saramaConfig()
+	if err != nil {
+		t.Fatalf("headers with zstd and the default version: %v", err)
+	}
+	if kc.Version != sarama.NewConfig().Version {
+		t.Errorf("headers changed the default version to %s", kc.Version)
+	}
+	if _, err := (&KafkaConfig{Headers: []string{KafkaHeaderLevel}, Version: "0.10.2.0"}).saramaConfig(); err == nil {
+		t.Error("headers with version 0.10 should be rejected")
+	}
+	if _, err := (&KafkaConfig{Headers: []string{KafkaHeaderLevel}, Version: "0.11.0.0"}).saramaConfig(); err != nil {
+		t.Errorf("headers with version 0.11: %v", err)
+	}
+}
+
+func TestKafkaCloseStuckProducer(t *testing.T) {
+	fp := newFakeProducer()
+	go func() { <-fp.input }() //只接收一条消息，之后不再读取Input()
+	c := &KafkaConfig{Topic: "logs", CloseTimeout: 100}
+	hook, err := newKafkaLogrusHook(logrus.AllLevels, &logrus.JSONFormatter{}, c, fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		hook.Fire(newKafkaEntry("stuck", nil))
+	}
+	begin := time.Now()
+	if err := hook.Close(); err == nil {
+		t.Error("close with a stuck producer reported no undelivered messages")
+	}
+	if d := time.Since(begin); d > time.Second {
+		t.Errorf("close took %s with a 100ms timeout", d)
+	}
+}
+
+func TestKafkaCloseReportsFailures(t *testing.T) {
+	const n = 5
+	hook, mp := newMockKafkaHook(t, &KafkaConfig{Topic: "logs", OnError: func(*sarama.ProducerError) {}})
+	for i := 0; i < n; i++ {
+		mp.ExpectInputAndFail(sarama.ErrOutOfBrokers)
+	}
+	for i := 0; i < n; i++ {
+		if err := hook.Fire(newKafkaEntry("lost", nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hook.Close(); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("%d messages", n)) {
+		t.Errorf("close after %d failed deliveries returned %v", n, err)
+	}
+	if stats := hook.Stats(); stats.Failed != n || stats.Dropped != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+// fireOne 通过mock producer发送entry，返回producer收到的消息
+func fireOne(t *testing.T, hook *KafkaLogrusHook, mp *mocks.AsyncProducer, entry *logrus.Entry) *sarama.ProducerMessage {
+	t.Helper()
+	msgs := make(chan *sarama.ProducerMessage, 1)
+	mp.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		msgs <- msg
+		return nil
+	})
+	if err := hook.Fire(entry); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-msgs:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message produced")
+	}
+	return nil
+}
+
+func TestKafkaPartitionKey(t *testing.T) {
+	ctx := ContextWithTrace(context.Background(), &Trace{TraceId: "trace-1"})
+	for _, c := range []struct {
+		name   string
+		config KafkaConfig
+		fields logrus.Fields
+		want   func(hook *KafkaLogrusHook, entry *logrus.Entry) sarama.Encoder
+	}{
+		{"time by default", KafkaConfig{}, nil, func(_ *KafkaLogrusHook, entry *logrus.Entry) sarama.Encoder {
+			b, _ := entry.Time.MarshalBinary()
+			return sarama.ByteEncoder(b)
+		}},
+		{"trace id", KafkaConfig{PartitionKey: KafkaPartitionByTraceId}, nil, func(*KafkaLogrusHook, *logrus.Entry) sarama.Encoder {
+			return sarama.StringEncoder("trace-1")
+		}},
+		{"field", KafkaConfig{PartitionKey: KafkaPartitionByField, PartitionField: "uid"}, logrus.Fields{"uid": 42},
+			func(*KafkaLogrusHook, *logrus.Entry) sarama.Encoder { return sarama.StringEncoder("42") }},
+		{"missing field", KafkaConfig{PartitionKey: KafkaPartitionByField, PartitionField: "uid"}, nil,
+			func(*KafkaLogrusHook, *logrus.Entry) sarama.Encoder { return nil }},
+		{"hostname", KafkaConfig{PartitionKey: KafkaPartitionByHostname}, nil, func(hook *KafkaLogrusHook, _ *logrus.Entry) sarama.Encoder {
+			return sarama.StringEncoder(hook.hostname)
+		}},
+		{"round robin", KafkaConfig{PartitionKey: KafkaPartitionRoundRobin}, nil,
+			func(*KafkaLogrusHook, *logrus.Entry) sarama.Encoder { return nil }},
+		{"none", KafkaConfig{PartitionKey: KafkaPartitionNone}, nil,
+			func(*KafkaLogrusHook, *logrus.Entry) sarama.Encoder { return nil }},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			config := c.config
+			config.Topic = "logs"
+			hook, mp := newMockKafkaHook(t, &config)
+			defer hook.Close()
+			entry := newKafkaEntry("hello", c.fields)
+			entry.Context = ctx
+			msg := fireOne(t, hook, mp, entry)
+			want := c.want(hook, entry)
+			if want == nil || msg.Key == nil {
+				if want != msg.Key {
+					t.Errorf("key %v, want %v", msg.Key, want)
+				}
+				return
+			}
+			got, _ := msg.Key.Encode()
+			if wantBytes, _ := want.Encode(); string(got) != string(wantBytes) {
+				t.Errorf("key %q, want %q", got, wantBytes)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package hlog
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	defaultKafkaBufferSize     = 10000
+	defaultKafkaReplayInterval = time.Second
+	defaultKafkaCloseTimeout   = 5 * time.Second
+	kafkaReplayQuietPeriod     = 5 * time.Second        //最近一次投递失败之后，等待多久再开始重放
+	kafkaBusyTimeout           = 200 * time.Millisecond //producer持续这么久不接收消息，认为kafka不可用，开始写入spool
+)
+
+// KafkaHookStats 统计kafka hook投递的消息条数
+type KafkaHookStats struct {
+	Spooled  uint64 //写入本地spool的条数
+	Replayed uint64 //从spool重放到kafka的条数
+	Dropped  uint64 //因缓冲区满或spool满而丢弃的条数
+	Failed   uint64 //producer投递失败且没有写入spool的条数
+}
+
+// kafkaProducer 由一个hook及其Clone出的hook共享
+type kafkaProducer struct {
+	config    *KafkaConfig
+	producer  sarama.AsyncProducer
+	spool     *kafkaSpool                  //未配置SpoolDir时为nil
+	buffer    chan *sarama.ProducerMessage //Fire与producer之间的缓冲区，由pump协程转交给producer
+	overflow  string
+	lastError atomic.Int64
+	inflight  atomic.Int64 //已交给producer、还没有收到投递结果的条数
+	spooled   atomic.Uint64
+	replayed  atomic.Uint64
+	dropped   atomic.Uint64
+	failed    atomic.Uint64
+	inputWg   sync.WaitGroup //向producer.Input()写入的协程
+	wg        sync.WaitGroup //读取producer投递结果的协程
+	closeOnce sync.Once
+	closeChan chan struct{}
+	abortChan chan struct{} //关闭超时后不再等待producer接收消息
+}
+
+func newKafkaProducer(c *KafkaConfig, producer sarama.AsyncProducer) (*kafkaProducer, error) {
+	p := &kafkaProducer{config: c, producer: producer, closeChan: make(chan struct{}), abortChan: make(chan struct{})}
+	if len(c.SpoolDir) > 0 {
+		spool, err := openKafkaSpool(c.SpoolDir, c.SpoolMaxSize)
+		if err != nil {
+			return nil, err
+		}
+		p.spool = spool
+	}
+	bufferSize := c.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultKafkaBufferSize
+	}
+	p.buffer = make(chan *sarama.ProducerMessage, bufferSize)
+	p.overflow = c.Overflow
+	switch p.overflow {
+	case "":
+		p.overflow = OverflowDropNewest
+	case OverflowDropNewest, OverflowDropOldest, OverflowBlock:
+	default: //spill会让溢出的消息排到缓冲区中更早的消息之前，不支持
+		fmt.Printf("invalid kafka overflow policy %s, use %s instead\n", p.overflow, OverflowDropNewest)
+		p.overflow = OverflowDropNewest
+	}
+	p.inputWg.Add(1)
+	go p.pump()
+	p.wg.Add(2)
+	go p.handleErrors()
+	go p.handleSuccesses()
+	if p.spool != nil {
+		p.inputWg.Add(1)
+		go p.replay()
+	}
+	return p, nil
+}
+
+// enqueue 将消息放入缓冲区，缓冲区满时按照配置的策略处理，Fire不会因为producer忙而一直阻塞
+func (p *kafkaProducer) enqueue(msg *sarama.ProducerMessage) {
+	select {
+	case <-p.closeChan: //已关闭
+		p.dropped.Add(1)
+		return
+	default:
+	}
+	if !enqueueOverflow(p.buffer, msg, p.overflow, time.Duration(p.config.BlockTimeout)*time.Millisecond, p.closeChan, &p.dropped) {
+		p.dropped.Add(1)
+	}
+}
+
+// pump 将缓冲区中的消息按顺序交给producer，关闭时先把缓冲区中剩余的消息交出去
+func (p *kafkaProducer) pump() {
+	defer p.inputWg.Done()
+	for {
+		select {
+		case msg := <-p.buffer:
+			p.send(msg)
+		case <-p.closeChan:
+			for {
+				select {
+				case msg := <-p.buffer:
+					p.send(msg)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// send 将消息交给producer；开启spool时，producer超过kafkaBusyTimeout仍不接收或spool中还有未重放的消息，
+// 都先写入spool以保证顺序
+func (p *kafkaProducer) send(msg *sarama.ProducerMessage) {
+	if p.spool == nil {
+		p.inflight.Add(1)
+		select {
+		case p.producer.Input() <- msg:
+		case <-p.abortChan:
+			p.inflight.Add(-1)
+			p.dropped.Add(1)
+		}
+		return
+	}
+	if !p.spool.pending() {
+		p.inflight.Add(1)
+		select {
+		case p.producer.Input() <- msg:
+			return
+		default:
+		}
+		timer := time.NewTimer(kafkaBusyTimeout) //producer的Input()没有缓冲，短暂的忙碌不算不可用
+		select {
+		case p.producer.Input() <- msg:
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-p.abortChan:
+			timer.Stop()
+		}
+		p.inflight.Add(-1)
+	}
+	p.spoolMessage(msg)
+}
+
+func (p *kafkaProducer) spoolMessage(msg *sarama.ProducerMessage) {
+	if err := p.spool.append(msg); err != nil {
+		p.dropped.Add(1)
+		log.Printf("Failed to spool log entry for Kafka: %v\n", err)
+		return
+	}
+	p.spooled.Add(1)
+}
+
+func (p *kafkaProducer) handleErrors() {
+	defer p.wg.Done()
+	for err := range p.producer.Errors() {
+		p.lastError.Store(time.Now().UnixNano())
+		if p.spool != nil && err.Msg != nil {
+			p.spoolMessage(err.Msg)
+		} else {
+			p.failed.Add(1)
+		}
+		p.inflight.Add(-1) //先计入failed，close看到inflight归零时失败的条数已经统计完
+		if p.config.OnError != nil {
+			p.config.OnError(err)
+		} else {
+			log.Printf("Failed to send log entry to Kafka: %v\n", err)
+		}
+	}
+}
+
+func (p *kafkaProducer) handleSuccesses() {
+	defer p.wg.Done()
+	for msg := range p.producer.Successes() {
+		p.inflight.Add(-1)
+		if p.config.OnSuccess != nil {
+			p.config.OnSuccess(msg)
+		}
+	}
+}
+
+// replay 在kafka恢复后按顺序重放spool中的消息，每次重放一个分段
+func (p *kafkaProducer) replay() {
+	defer p.inputWg.Done()
+	ticker := time.NewTicker(defaultKafkaReplayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closeChan:
+			return
+		case <-ticker.C:
+		}
+		for p.spool.pending() && p.healthy() {
+			seq, msgs, err := p.spool.oldest()
+			if err != nil {
+				log.Printf("Failed to read Kafka spool: %v\n", err)
+				break
+			}
+			for _, msg := range msgs {
+				p.inflight.Add(1)
+				select {
+				case p.producer.Input() <- msg:
+					p.replayed.Add(1)
+				case <-p.closeChan: //整个分段留在spool中，下次启动时重放，已发送的部分会重复
+					p.inflight.Add(-1)
+					return
+				}
+			}
+			if err = p.spool.remove(seq); err != nil {
+				log.Printf("Failed to remove Kafka spool segment: %v\n", err)
+			}
+		}
+	}
+}
+
+// close 停止接收新消息，在timeout内将缓冲区中的消息交给producer并等待投递结果，然后关闭producer，
+// 返回未能投递也未写入spool的总条数，即丢弃、投递失败与关闭超时时仍未收到结果的条数之和，重复调用时返回0
+func (p *kafkaProducer) close(timeout time.Duration) (undelivered int64) {
+	p.closeOnce.Do(func() {
+		expired := make(chan struct{}) //超时后关闭，之后的每次等待都会立即返回
+		deadline := time.AfterFunc(timeout, func() { close(expired) })
+		defer deadline.Stop()
+
+		close(p.closeChan)
+		inputDone := make(chan struct{})
+		go func() {
+			p.inputWg.Wait()
+			close(inputDone)
+		}()
+		select {
+		case <-inputDone:
+		case <-expired:
+			close(p.abortChan)
+			<-inputDone
+		}
+
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+	wait:
+		for p.inflight.Load() > 0 {
+			select {
+			case <-ticker.C:
+			case <-expired:
+				break wait
+			}
+		}
+
+		p.producer.AsyncClose() //之后producer会关闭Errors()与Successes()，处理结果的协程随之退出
+		outputDone := make(chan struct{})
+		go func() {
+			p.wg.Wait()
+			close(outputDone)
+		}()
+		select {
+		case <-outputDone:
+			if p.spool != nil {
+				p.spool.close()
+			}
+		case <-expired:
+		}
+		undelivered = p.inflight.Load() + int64(p.dropped.Load()+p.failed.Load())
+	})
+	return
+}
+
+// healthy 最近一段时间内没有投递失败，认为kafka已经恢复
+func (p *kafkaProducer) healthy() bool {
+	return time.Since(time.Unix(0, p.lastError.Load())) > kafkaReplayQuietPeriod
+}
+
+func (p *kafkaProducer) stats() KafkaHookStats {
+	return KafkaHookStats{
+		Spooled:  p.spooled.Load(),
+		Replayed: p.replayed.Load(),
+		Dropped:  p.dropped.Load(),
+		Failed:   p.failed.Load(),
+	}
+}
@@ -0,0 +1,81 @@
+package hlog
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// kafkaRoute 是解析过level的KafkaRoute
+type kafkaRoute struct {
+	KafkaRoute
+	levels map[logrus.Level]bool
+	tags   map[string]bool
+}
+
+func compileKafkaRoutes(routes []KafkaRoute) ([]kafkaRoute, error) {
+	compiled := make([]kafkaRoute, 0, len(routes))
+	for i, r := range routes {
+		if len(r.Topic) == 0 {
+			return nil, fmt.Errorf("kafka route %d has no topic", i)
+		}
+		route := kafkaRoute{KafkaRoute: r}
+		if len(r.Levels) > 0 {
+			route.levels = make(map[logrus.Level]bool, len(r.Levels))
+			for _, l := range r.Levels {
+				level, err := logrus.ParseLevel(l)
+				if err != nil {
+					return nil, fmt.Errorf("kafka route %d: %v", i, err)
+				}
+				route.levels[level] = true
+			}
+		}
+		if len(r.Tags) > 0 {
+			route.tags = make(map[string]bool, len(r.Tags))
+			for _, t := range r.Tags {
+				route.tags[t] = true
+			}
+		}
+		compiled = append(compiled, route)
+	}
+	return compiled, nil
+}
+
+// match 返回entry是否满足路由的所有条件
+func (r *kafkaRoute) match(entry *logrus.Entry) bool {
+	if r.levels != nil && !r.levels[entry.Level] {
+		return false
+	}
+	if r.tags != nil {
+		tag := LogTagUndef
+		if t, ok := entry.Data[LogTag].(string); ok {
+			tag = t
+		}
+		if !r.tags[tag] {
+			return false
+		}
+	}
+	if len(r.Field) > 0 {
+		v, ok := entry.Data[r.Field]
+		if !ok {
+			return false
+		}
+		if len(r.Value) > 0 && fmt.Sprintf("%v", v) != r.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// topic 返回entry应写入的topic：entry中字符串类型的topic字段优先，其次为第一条匹配的路由，最后为KafkaConfig.Topic
+func (hook *KafkaLogrusHook) topic(entry *logrus.Entry) string {
+	if ts, ok := entry.Data["topic"].(string); ok && len(ts) > 0 {
+		return ts
+	}
+	for i := range hook.routes {
+		if hook.routes[i].match(entry) {
+			return hook.routes[i].Topic
+		}
+	}
+	return hook.config.Topic
+}
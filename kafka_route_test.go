@@ -0,0 +1,67 @@
+package hlog
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestKafkaRoutes(t *testing.T) {
+	hook, mp := newMockKafkaHook(t, &KafkaConfig{
+		Topic: "logs",
+		Routes: []KafkaRoute{
+			{Topic: "errors", Levels: []string{"error", "fatal"}},
+			{Topic: "access", Tags: []string{LogTagAccessIn, LogTagAccessOut}},
+			{Topic: "vip", Field: "vip"},
+			{Topic: "pay", Field: "biz", Value: "pay"},
+			{Topic: "slow-access", Tags: []string{LogTagAccessOut}, Field: "slow"}, //被前面的access路由挡住
+		},
+	})
+	defer hook.Close()
+	for _, c := range []struct {
+		name   string
+		level  logrus.Level
+		fields logrus.Fields
+		want   string
+	}{
+		{"no match", logrus.InfoLevel, nil, "logs"},
+		{"level", logrus.ErrorLevel, nil, "errors"},
+		{"level before tag", logrus.ErrorLevel, logrus.Fields{LogTag: LogTagAccessOut}, "errors"},
+		{"tag", logrus.InfoLevel, logrus.Fields{LogTag: LogTagAccessIn}, "access"},
+		{"first match wins", logrus.InfoLevel, logrus.Fields{LogTag: LogTagAccessOut, "slow": true}, "access"},
+		{"field exists", logrus.InfoLevel, logrus.Fields{"vip": false}, "vip"},
+		{"field before value", logrus.InfoLevel, logrus.Fields{"vip": 1, "biz": "pay"}, "vip"},
+		{"field value", logrus.InfoLevel, logrus.Fields{"biz": "pay"}, "pay"},
+		{"field value mismatch", logrus.InfoLevel, logrus.Fields{"biz": "game"}, "logs"},
+		{"topic field overrides routes", logrus.ErrorLevel, logrus.Fields{"topic": "custom"}, "custom"},
+		{"empty topic field is ignored", logrus.ErrorLevel, logrus.Fields{"topic": ""}, "errors"},
+		{"non-string topic field is ignored", logrus.InfoLevel, logrus.Fields{"topic": 42, "biz": "pay"}, "pay"},
+	} {
+		entry := newKafkaEntry("hello", c.fields)
+		entry.Level = c.level
+		if msg := fireOne(t, hook, mp, entry); msg.Topic != c.want {
+			t.Errorf("%s: topic %s, want %s", c.name, msg.Topic, c.want)
+		}
+	}
+}
+
+func TestCompileKafkaRoutes(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		routes []KafkaRoute
+		ok     bool
+	}{
+		{"no routes", nil, true},
+		{"valid", []KafkaRoute{{Topic: "errors", Levels: []string{"error"}}}, true},
+		{"missing topic", []KafkaRoute{{Levels: []string{"error"}}}, false},
+		{"bad level", []KafkaRoute{{Topic: "errors", Levels: []string{"loud"}}}, false},
+	} {
+		if _, err := compileKafkaRoutes(c.routes); (err == nil) != c.ok {
+			t.Errorf("%s: err %v", c.name, err)
+		}
+	}
+	if _, err := newKafkaLogrusHook(logrus.AllLevels, &logrus.JSONFormatter{},
+		&KafkaConfig{Routes: []KafkaRoute{{}}}, newFakeProducer()); err == nil {
+		t.Error("a hook with an invalid route was created")
+	}
+}
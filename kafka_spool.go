@@ -0,0 +1,208 @@
+package hlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	kafkaSpoolPrefix         = "spool-"
+	kafkaSpoolExt            = ".log"
+	defaultKafkaSpoolMaxSize = 1024 //MB
+	kafkaSpoolSegmentSize    = 8 * MEGABYTE
+)
+
+var errKafkaSpoolFull = errors.New("kafka spool is full")
+
+// kafkaSpoolRecord 是spool文件中的一行，[]byte以base64编码
+type kafkaSpoolRecord struct {
+	Topic   string                `json:"topic"`
+	Key     []byte                `json:"key,omitempty"`
+	Value   []byte                `json:"value"`
+	Headers []sarama.RecordHeader `json:"headers,omitempty"`
+}
+
+// kafkaSpool 将kafka暂时无法接收的消息按顺序追加到本地的分段文件中，恢复后再按顺序重放
+type kafkaSpool struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	segments []uint64         //所有分段的序号，从旧到新
+	sizes    map[uint64]int64 //每个分段的大小
+	total    int64
+	w        *os.File //正在写入的分段，总是segments中的最后一个
+	wSeq     uint64
+}
+
+func openKafkaSpool(dir string, maxSize int64) (*kafkaSpool, error) {
+	if maxSize <= 0 {
+		maxSize = defaultKafkaSpoolMaxSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &kafkaSpool{dir: dir, maxBytes: maxSize * MEGABYTE, sizes: map[uint64]int64{}}
+	for _, f := range files { //上次进程退出时没有重放完的消息
+		if seq, ok := kafkaSpoolSeq(f.Name()); ok && !f.IsDir() {
+			s.segments = append(s.segments, seq)
+			s.sizes[seq] = f.Size()
+			s.total += f.Size()
+		}
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+	return s, nil
+}
+
+func kafkaSpoolSeq(name string) (uint64, bool) {
+	if !strings.HasPrefix(name, kafkaSpoolPrefix) || !strings.HasSuffix(name, kafkaSpoolExt) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(name[len(kafkaSpoolPrefix):len(name)-len(kafkaSpoolExt)], 10, 64)
+	return seq, err == nil
+}
+
+func (s *kafkaSpool) segmentName(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s%020d%s", kafkaSpoolPrefix, seq, kafkaSpoolExt))
+}
+
+// pending 返回spool中是否还有未重放的消息
+func (s *kafkaSpool) pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total > 0
+}
+
+// append 将消息追加到spool中，超过大小上限时返回errKafkaSpoolFull
+func (s *kafkaSpool) append(msg *sarama.ProducerMessage) error {
+	record := kafkaSpoolRecord{Topic: msg.Topic, Headers: msg.Headers}
+	var err error
+	if msg.Key != nil {
+		if record.Key, err = msg.Key.Encode(); err != nil {
+			return err
+		}
+	}
+	if msg.Value != nil {
+		if record.Value, err = msg.Value.Encode(); err != nil {
+			return err
+		}
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.total+int64(len(line)) > s.maxBytes {
+		return errKafkaSpoolFull
+	}
+	if s.w == nil || s.sizes[s.wSeq] >= kafkaSpoolSegmentSize {
+		if err = s.rollLocked(); err != nil {
+			return err
+		}
+	}
+	n, err := s.w.Write(line)
+	s.sizes[s.wSeq] += int64(n)
+	s.total += int64(n)
+	return err
+}
+
+// rollLocked 关闭正在写入的分段，并开启一个新的分段，调用方需持有s.mu
+func (s *kafkaSpool) rollLocked() error {
+	if s.w != nil {
+		s.w.Close()
+		s.w = nil
+	}
+	var seq uint64
+	if len(s.segments) > 0 {
+		seq = s.segments[len(s.segments)-1] + 1
+	}
+	f, err := os.OpenFile(s.segmentName(seq), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.w, s.wSeq = f, seq
+	s.segments = append(s.segments, seq)
+	s.sizes[seq] = 0
+	return nil
+}
+
+// oldest 读出最旧的分段中的所有消息，该分段正在写入时先切换到新的分段
+func (s *kafkaSpool) oldest() (uint64, []*sarama.ProducerMessage, error) {
+	s.mu.Lock()
+	if len(s.segments) == 0 {
+		s.mu.Unlock()
+		return 0, nil, nil
+	}
+	seq := s.segments[0]
+	if s.w != nil && s.wSeq == seq {
+		s.w.Close()
+		s.w = nil
+	}
+	s.mu.Unlock()
+
+	f, err := os.Open(s.segmentName(seq))
+	if err != nil {
+		return seq, nil, err
+	}
+	defer f.Close()
+	var msgs []*sarama.ProducerMessage
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' { //不完整的最后一行是写入时崩溃留下的，丢弃
+			var record kafkaSpoolRecord
+			if json.Unmarshal(line, &record) == nil {
+				msg := &sarama.ProducerMessage{Topic: record.Topic, Value: sarama.ByteEncoder(record.Value), Headers: record.Headers}
+				if record.Key != nil {
+					msg.Key = sarama.ByteEncoder(record.Key)
+				}
+				msgs = append(msgs, msg)
+			}
+		}
+		if err == io.EOF {
+			return seq, msgs, nil
+		} else if err != nil {
+			return seq, msgs, err
+		}
+	}
+}
+
+// remove 删除已经重放完的分段
+func (s *kafkaSpool) remove(seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.segments) == 0 || s.segments[0] != seq {
+		return nil
+	}
+	s.segments = s.segments[1:]
+	s.total -= s.sizes[seq]
+	delete(s.sizes, seq)
+	return os.Remove(s.segmentName(seq))
+}
+
+func (s *kafkaSpool) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w != nil {
+		s.w.Close()
+		s.w = nil
+	}
+}
@@ -1,9 +1,11 @@
 package hlog
 
 import (
+	"context"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"io"
+	"io/ioutil"
 	"net/http"
 )
 
@@ -20,6 +22,9 @@ func newLogger(c *Config, w io.Writer, workerId int64) (log *Logger) {
 	logger := &Logger{exitChan: make(chan struct{}), config: c, fields: logrus.Fields{}, logid: workerId}
 	logger.Out = w
 	logger.Formatter = LogFormatter(c, logger.fields, workerId)
+	if f, ok := logger.Formatter.(*DefaultLogFormatter); ok && len(c.Sinks) > 0 {
+		f.discard = true
+	}
 	logger.Hooks = make(logrus.LevelHooks)
 	logger.Level = c.level
 	return logger
@@ -35,6 +40,8 @@ func (l *Logger) Clone(workerId int64) (log *Logger) {
 		for i, h := range hooks {
 			if kafkaHook, ok := h.(*KafkaLogrusHook); ok {
 				log.Hooks[level][i] = kafkaHook.Clone(log.Formatter)
+			} else if sh, ok := h.(*sinkHook); ok {
+				log.Hooks[level][i] = sh.clone(log.Formatter)
 			}
 		}
 	}
@@ -52,10 +59,22 @@ func NewLoggerWithConfig(c *Config, workerId int64) (l *Logger) {
 		c.level = logrus.InfoLevel
 	}
 	l = newLogger(c, nil, workerId)
-	l.Out = newFileWriter(c.File, l.wg, l.exitChan)
+	if len(c.Sinks) > 0 { //日志由各个sink按自己的级别输出，Logger的级别取其中最详细的
+		hooks, level := newSinkHooks(c, l.Formatter)
+		for _, h := range hooks {
+			l.Hooks.Add(h)
+		}
+		c.level = level
+		l.Level = level
+		l.Out = ioutil.Discard
+		return
+	}
+	l.Out = newFileWriter(c.File, &l.wg, l.exitChan)
 	if c.Kafka != nil {
 		if h, err := NewKafkaHookWithFormatter(l.Formatter, c.Kafka, c.level); err == nil {
 			l.Hooks.Add(h)
+		} else {
+			fmt.Printf("new kafka hook error: %v, kafka output disabled\n", err)
 		}
 	}
 	return
@@ -80,21 +99,131 @@ func (l *Logger) SetTraceId(traceId string) {
 func (l *Logger) ClearTrace() {
 	l.Formatter.(*DefaultLogFormatter).clearTrace()
 }
-func (l *Logger) Close() {
+
+// Close flushes and closes the logger's Kafka hooks and sinks, then stops the file writer,
+// the returned error reports messages that could not be delivered to Kafka
+func (l *Logger) Close() error {
+	var err error
+	closed := make(map[logrus.Hook]bool) //同一个hook会注册在多个level下
+	for _, hooks := range l.Hooks {
+		for _, h := range hooks {
+			if closed[h] {
+				continue
+			}
+			closed[h] = true
+			var e error
+			if kafkaHook, ok := h.(*KafkaLogrusHook); ok {
+				e = kafkaHook.Close()
+			} else if sh, ok := h.(*sinkHook); ok {
+				e = sh.Close()
+			}
+			if e != nil && err == nil {
+				err = e
+			}
+		}
+	}
 	close(l.exitChan)
 	l.wg.Wait()
+	return err
+}
+
+// FileStats returns the write/drop/spill/failure counters of the logger's FileWriter,
+// or their sums over all file sinks when sinks are configured, see SinkStats for each sink
+func (l *Logger) FileStats() (stats FileWriterStats) {
+	if fw, ok := l.Out.(*FileWriter); ok {
+		return fw.Stats()
+	}
+	for _, h := range l.sinkHooks() {
+		if fs, ok := h.sink.(*fileSink); ok {
+			s := fs.fw.Stats()
+			stats.Written += s.Written
+			stats.Dropped += s.Dropped
+			stats.Spilled += s.Spilled
+			stats.Failed += s.Failed
+		}
+	}
+	return
+}
+
+// sinkHooks 按配置的顺序返回Logger的sink hook，每个sink都注册在PanicLevel下
+func (l *Logger) sinkHooks() (hooks []*sinkHook) {
+	for _, h := range l.Hooks[logrus.PanicLevel] {
+		if sh, ok := h.(*sinkHook); ok {
+			hooks = append(hooks, sh)
+		}
+	}
+	return
+}
+
+// KafkaStats returns the spool/replay/drop/failure counters of the logger's Kafka hook,
+// or their sums over all kafka sinks when sinks are configured, see SinkStats for each sink
+func (l *Logger) KafkaStats() (stats KafkaHookStats) {
+	for _, hooks := range l.Hooks {
+		for _, h := range hooks {
+			if kafkaHook, ok := h.(*KafkaLogrusHook); ok {
+				return kafkaHook.Stats()
+			}
+		}
+	}
+	for _, h := range l.sinkHooks() {
+		if ks, ok := h.sink.(*kafkaSink); ok {
+			s := ks.KafkaLogrusHook.Stats()
+			stats.Spooled += s.Spooled
+			stats.Replayed += s.Replayed
+			stats.Dropped += s.Dropped
+			stats.Failed += s.Failed
+		}
+	}
+	return
+}
+
+// SinkStats returns the counters of each sink in the order of Config.Sinks, sinks that could
+// not be created are skipped and sinks that do not implement StatsSink only report their Type
+func (l *Logger) SinkStats() []SinkStats {
+	hooks := l.sinkHooks()
+	stats := make([]SinkStats, 0, len(hooks))
+	for _, h := range hooks {
+		var s SinkStats
+		if ss, ok := h.sink.(StatsSink); ok {
+			s = ss.Stats()
+		}
+		s.Type = h.config.Type
+		stats = append(stats, s)
+	}
+	return stats
 }
 
 func (l *Logger) ParseTrace(req *http.Request) {
 	l.Formatter.(*DefaultLogFormatter).parseTrace(req)
 }
 
+// ParseTraceContext returns req's context carrying the trace parsed from req (or a new one),
+// log with l.WithContext(ctx) to use it without touching the logger's shared trace
+func (l *Logger) ParseTraceContext(req *http.Request) context.Context {
+	return l.Formatter.(*DefaultLogFormatter).parseTraceContext(req)
+}
+
 func (l *Logger) AddHttpTrace(req *http.Request) string {
-	return l.Formatter.(*DefaultLogFormatter).addHttpTrace(req)
+	return l.Formatter.(*DefaultLogFormatter).addHttpTrace(context.Background(), req)
+}
+
+// AddHttpTraceContext sets the trace carried by ctx, or the logger's own, on an outgoing request
+func (l *Logger) AddHttpTraceContext(ctx context.Context, req *http.Request) string {
+	return l.Formatter.(*DefaultLogFormatter).addHttpTrace(ctx, req)
 }
 
 func (l *Logger) AddRspTrace(rsp *http.ResponseWriter) string {
-	return l.Formatter.(*DefaultLogFormatter).addRspTrace(rsp)
+	return l.Formatter.(*DefaultLogFormatter).addRspTrace(context.Background(), *rsp)
+}
+
+// AddRspTraceContext sets the trace carried by ctx, or the logger's own, on the response headers
+func (l *Logger) AddRspTraceContext(ctx context.Context, rsp http.ResponseWriter) string {
+	return l.Formatter.(*DefaultLogFormatter).addRspTrace(ctx, rsp)
+}
+
+// GetTraceIdContext returns the trace id carried by ctx, or the logger's own
+func (l *Logger) GetTraceIdContext(ctx context.Context) string {
+	return l.Formatter.(*DefaultLogFormatter).contextTrace(ctx).TraceId
 }
 
 func (l *Logger) GetTrace() *Trace {
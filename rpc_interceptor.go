@@ -0,0 +1,72 @@
+package hlog
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	rpcSideServer = "server"
+	rpcSideClient = "client"
+)
+
+// RpcInterceptor carries trace ids over rpc headers and logs each call with a success tag
+// or its registered failure counterpart. It does not depend on any rpc framework,
+// e.g. in a grpc unary server interceptor:
+//
+//	md, _ := metadata.FromIncomingContext(ctx)
+//	err = i.Server(ctx, hlog.MetadataCarrier(md), info.FullMethod, func(ctx context.Context) (err error) {
+//		rsp, err = handler(ctx, req)
+//		return err
+//	})
+//
+// and in a grpc unary client interceptor:
+//
+//	md := metadata.MD{}
+//	return i.Client(ctx, hlog.MetadataCarrier(md), method, func(ctx context.Context) error {
+//		return invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+//	})
+type RpcInterceptor struct {
+	Logger *Logger
+	Tag    string //成功时使用的tag，默认为LogTagRpcOk，thrift可使用LogTagThriftOk
+}
+
+// NewRpcInterceptor returns an RpcInterceptor logging through l with the success tag tag
+func (l *Logger) NewRpcInterceptor(tag string) *RpcInterceptor {
+	return &RpcInterceptor{Logger: l, Tag: tag}
+}
+
+// Server extracts the trace from carrier into ctx, runs handler with it and logs the call
+func (i *RpcInterceptor) Server(ctx context.Context, carrier TraceCarrier, method string, handler func(ctx context.Context) error) (err error) {
+	ctx = ContextWithLogger(i.Logger.ExtractTrace(ctx, carrier), i.Logger)
+	rec := i.start(ctx, rpcSideServer, method)
+	defer func() {
+		if p := recover(); p != nil {
+			rec.finish(false, nil, nil)
+			panic(p)
+		}
+		rec.Finish(err)
+	}()
+	return handler(ctx)
+}
+
+// Client injects the trace carried by ctx into carrier, runs call and logs the call
+func (i *RpcInterceptor) Client(ctx context.Context, carrier TraceCarrier, method string, call func(ctx context.Context) error) error {
+	i.Logger.InjectTrace(ctx, carrier)
+	rec := i.start(ctx, rpcSideClient, method)
+	err := call(ctx)
+	rec.Finish(err)
+	return err
+}
+
+func (i *RpcInterceptor) start(ctx context.Context, side, method string) *CallRecorder {
+	tag := i.Tag
+	if len(tag) == 0 {
+		tag = LogTagRpcOk
+	}
+	return i.Logger.StartCall(ctx, tag, logrus.Fields{
+		"method":   method,
+		"rpc_side": side,
+	})
+}
@@ -0,0 +1,259 @@
+package hlog
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 内置的sink类型
+const (
+	SinkTypeFile  = "file"
+	SinkTypeKafka = "kafka"
+)
+
+// Sink is a log output created from a SinkConfig. Fire is called for every entry at or
+// above the sink's level and formats the entry with the formatter given to its factory.
+type Sink interface {
+	Fire(entry *logrus.Entry) error
+	Close() error
+}
+
+// CloneableSink is implemented by sinks that can switch to a cloned Logger's formatter,
+// the clone shares the sink's underlying output and must not close it.
+// Sinks that do not implement it keep formatting with the original Logger's trace.
+type CloneableSink interface {
+	Sink
+	Clone(f logrus.Formatter) Sink
+}
+
+// SinkStats holds the delivery counters of one sink, counters a sink does not keep stay zero
+type SinkStats struct {
+	Type     string //SinkConfig.Type，由Logger.SinkStats填写
+	Written  uint64 //已写入输出目标的条数
+	Dropped  uint64 //因队列满、已关闭等原因丢弃的条数
+	Failed   uint64 //写入或投递失败的条数
+	Spilled  uint64 //file sink写入旁路文件的条数
+	Spooled  uint64 //kafka sink写入本地spool的条数
+	Replayed uint64 //kafka sink从spool重放的条数
+}
+
+// StatsSink is implemented by sinks that keep delivery counters, see Logger.SinkStats.
+// The file and kafka sinks and the sinks created by NewWriterSink implement it.
+type StatsSink interface {
+	Sink
+	Stats() SinkStats
+}
+
+// SinkFactory creates a sink, f is built from c.Format and shares the Logger's trace and fields
+type SinkFactory func(c *SinkConfig, f logrus.Formatter) (Sink, error)
+
+type sinkRegistry struct {
+	mu        sync.RWMutex
+	factories map[string]SinkFactory
+}
+
+var registeredSinks = &sinkRegistry{factories: map[string]SinkFactory{}}
+
+func init() {
+	RegisterSink(SinkTypeFile, newFileSink)
+	RegisterSink(SinkTypeKafka, newKafkaSink)
+}
+
+// RegisterSink registers or replaces the factory of a sink type, it is safe for concurrent use
+func RegisterSink(typ string, factory SinkFactory) error {
+	if len(typ) == 0 || factory == nil {
+		return errors.New("empty sink type or factory")
+	}
+	registeredSinks.mu.Lock()
+	defer registeredSinks.mu.Unlock()
+	registeredSinks.factories[typ] = factory
+	return nil
+}
+
+func lookupSink(typ string) (SinkFactory, bool) {
+	registeredSinks.mu.RLock()
+	defer registeredSinks.mu.RUnlock()
+	factory, ok := registeredSinks.factories[typ]
+	return factory, ok
+}
+
+// sinkHook 将sink以logrus hook的方式挂到Logger上，按sink自己的级别过滤
+type sinkHook struct {
+	config *SinkConfig
+	level  logrus.Level
+	levels []logrus.Level
+	sink   Sink
+	owner  bool //Clone出的hook与原hook共享sink，只有原hook负责关闭
+}
+
+func (h *sinkHook) Levels() []logrus.Level {
+	return h.levels
+}
+
+func (h *sinkHook) Fire(entry *logrus.Entry) error {
+	return h.sink.Fire(entry)
+}
+
+func (h *sinkHook) Close() error {
+	if !h.owner {
+		return nil
+	}
+	return h.sink.Close()
+}
+
+// clone 返回使用新Logger的formatter的hook
+func (h *sinkHook) clone(root logrus.Formatter) *sinkHook {
+	sink := h.sink
+	if cs, ok := sink.(CloneableSink); ok {
+		sink = cs.Clone(newSinkFormatter(root, h.config.Format))
+	}
+	return &sinkHook{h.config, h.level, h.levels, sink, false}
+}
+
+// newSinkHooks 按配置创建所有sink，创建失败的sink被跳过，返回的level为所有sink中最详细的级别
+func newSinkHooks(c *Config, root logrus.Formatter) (hooks []*sinkHook, level logrus.Level) {
+	level = logrus.PanicLevel
+	for i, sc := range c.Sinks {
+		if sc == nil {
+			continue
+		}
+		factory, ok := lookupSink(sc.Type)
+		if !ok {
+			fmt.Printf("unknown sink type %s, sink %d disabled\n", sc.Type, i)
+			continue
+		}
+		sinkLevel := c.level
+		if len(sc.Level) > 0 {
+			var err error
+			if sinkLevel, err = logrus.ParseLevel(sc.Level); err != nil {
+				fmt.Printf("parse sink level %s error: %v, use %s instead\n", sc.Level, err, c.level)
+				sinkLevel = c.level
+			}
+		}
+		sink, err := factory(sc, newSinkFormatter(root, sc.Format))
+		if err != nil {
+			fmt.Printf("new %s sink error: %v, sink %d disabled\n", sc.Type, err, i)
+			continue
+		}
+		var levels []logrus.Level
+		for _, l := range logrus.AllLevels {
+			if l <= sinkLevel {
+				levels = append(levels, l)
+			}
+		}
+		hooks = append(hooks, &sinkHook{sc, sinkLevel, levels, sink, true})
+		if sinkLevel > level {
+			level = sinkLevel
+		}
+	}
+	return
+}
+
+// newSinkFormatter 返回sink使用的formatter，默认formatter时按fc生成一个共享root的trace与字段的formatter
+func newSinkFormatter(root logrus.Formatter, fc *FormatterConfig) logrus.Formatter {
+	df, ok := root.(*DefaultLogFormatter)
+	if !ok { //自定义的LogFormatter直接共用
+		return root
+	}
+	f := &DefaultLogFormatter{
+		WorkerId:         df.WorkerId,
+		Type:             df.Type,
+		FullTimestamp:    df.FullTimestamp,
+		TimestampFormat:  df.TimestampFormat,
+		DisableSorting:   df.DisableSorting,
+		DisableLog:       df.DisableLog,
+		TraceHeader:      df.TraceHeader,
+		TracePropagation: df.TracePropagation,
+		Fields:           df.Fields,
+		traceOwner:       df,
+	}
+	if fc != nil {
+		c := *fc
+		if err := c.validate(); err != nil {
+			fmt.Printf("invalid sink format config: %v, use default instead\n", err)
+		}
+		f.Type = c.Type
+		f.FullTimestamp = c.FullTimestamp
+		f.TimestampFormat = c.TimestampFormat
+		f.DisableSorting = c.DisableSorting
+		f.DisableLog = c.DisableLog
+	}
+	return f
+}
+
+// fileSink 是内置的file sink，每个file sink有自己的FileWriter与写入队列
+type fileSink struct {
+	fw        *FileWriter
+	formatter logrus.Formatter
+	wg        *WaitGroupWrapper
+	quitChan  chan struct{}
+}
+
+func newFileSink(c *SinkConfig, f logrus.Formatter) (Sink, error) {
+	s := &fileSink{formatter: f, wg: &WaitGroupWrapper{}, quitChan: make(chan struct{})}
+	s.fw = newFileWriter(c.File, s.wg, s.quitChan)
+	return s, nil
+}
+
+func (s *fileSink) Fire(entry *logrus.Entry) error {
+	b, err := s.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.fw.Write(b)
+	return err
+}
+
+// Close 等待队列中的日志写完后关闭文件
+func (s *fileSink) Close() error {
+	close(s.quitChan)
+	s.wg.Wait()
+	return nil
+}
+
+func (s *fileSink) Clone(f logrus.Formatter) Sink {
+	return &fileSink{s.fw, f, s.wg, s.quitChan}
+}
+
+func (s *fileSink) Stats() SinkStats {
+	stats := s.fw.Stats()
+	return SinkStats{
+		Written: stats.Written,
+		Dropped: stats.Dropped,
+		Failed:  stats.Failed,
+		Spilled: stats.Spilled,
+	}
+}
+
+// kafkaSink 是内置的kafka sink
+type kafkaSink struct {
+	*KafkaLogrusHook
+}
+
+func newKafkaSink(c *SinkConfig, f logrus.Formatter) (Sink, error) {
+	if c.Kafka == nil {
+		return nil, errors.New("no kafka config")
+	}
+	hook, err := NewKafkaLogrusHook(logrus.AllLevels, KafkaFormatter(f, c.Kafka), c.Kafka, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaSink{hook}, nil
+}
+
+func (s *kafkaSink) Clone(f logrus.Formatter) Sink {
+	return &kafkaSink{s.KafkaLogrusHook.Clone(f)}
+}
+
+func (s *kafkaSink) Stats() SinkStats {
+	stats := s.KafkaLogrusHook.Stats()
+	return SinkStats{
+		Dropped:  stats.Dropped,
+		Failed:   stats.Failed,
+		Spooled:  stats.Spooled,
+		Replayed: stats.Replayed,
+	}
+}
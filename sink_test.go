@@ -0,0 +1,184 @@
+package hlog
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/sirupsen/logrus"
+)
+
+const testWriterSinkType = "test_writer"
+
+func init() {
+	//Options["out"]为写入的目标
+	RegisterSink(testWriterSinkType, func(c *SinkConfig, f logrus.Formatter) (Sink, error) {
+		return NewWriterSink(c, f, c.Options["out"].(io.Writer)), nil
+	})
+}
+
+func TestSinkLevelAndFormat(t *testing.T) {
+	verbose, errorsOnly := &syncBuffer{}, &syncBuffer{}
+	l := NewLoggerWithConfig(&Config{Level: "info", Sinks: []*SinkConfig{
+		{Type: testWriterSinkType, Level: "debug", Format: &FormatterConfig{Type: FormatTypeJson},
+			Options: map[string]interface{}{"out": verbose}},
+		{Type: testWriterSinkType, Level: "error", Options: map[string]interface{}{"out": errorsOnly}},
+		{Type: "unknown"},
+	}}, 1)
+	if l.GetLevel() != logrus.DebugLevel {
+		t.Errorf("logger level %s, want the most verbose sink level", l.GetLevel())
+	}
+	l.Debug("debug")
+	l.Info("info")
+	l.Error("error")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := verbose.jsonLines(t)
+	if len(lines) != 3 || lines[0][jsonKeyMsg] != "debug" || lines[2][jsonKeyLevel] != "ERROR" {
+		t.Errorf("debug json sink got %v", lines)
+	}
+	text := strings.Split(strings.TrimSpace(errorsOnly.String()), "\n")
+	if len(text) != 1 || !strings.HasPrefix(text[0], "[ERROR]") || json.Valid([]byte(text[0])) {
+		t.Errorf("error text sink got %q", errorsOnly.String())
+	}
+	stats := l.SinkStats()
+	if len(stats) != 2 || stats[0] != (SinkStats{Type: testWriterSinkType, Written: 3}) ||
+		stats[1] != (SinkStats{Type: testWriterSinkType, Written: 1}) {
+		t.Errorf("unexpected sink stats %+v", stats)
+	}
+}
+
+func TestRegisterSink(t *testing.T) {
+	if err := RegisterSink("", func(*SinkConfig, logrus.Formatter) (Sink, error) { return nil, nil }); err == nil {
+		t.Error("registered an empty sink type")
+	}
+	if err := RegisterSink("test_nil", nil); err == nil {
+		t.Error("registered a nil factory")
+	}
+	var got *SinkConfig
+	RegisterSink("test_custom", func(c *SinkConfig, f logrus.Formatter) (Sink, error) {
+		got = c
+		return NewWriterSink(c, f, io.Discard), nil
+	})
+	sc := &SinkConfig{Type: "test_custom", Options: map[string]interface{}{"addr": "127.0.0.1:514"}}
+	l := NewLoggerWithConfig(&Config{Level: "info", Sinks: []*SinkConfig{sc}}, 1)
+	l.Info("hello")
+	l.Close()
+	if got != sc {
+		t.Error("the factory did not receive its sink config")
+	}
+	if stats := l.SinkStats(); len(stats) != 1 || stats[0].Type != "test_custom" || stats[0].Written != 1 {
+		t.Errorf("unexpected sink stats %+v", stats)
+	}
+}
+
+// blockWriter 收到第一条日志后阻塞，直到release关闭
+type blockWriter struct {
+	received chan struct{}
+	release  chan struct{}
+	once     sync.Once
+}
+
+func (w *blockWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.received) })
+	<-w.release
+	return len(p), nil
+}
+
+func TestWriterSinkStats(t *testing.T) {
+	w := &blockWriter{received: make(chan struct{}), release: make(chan struct{})}
+	s := NewWriterSink(&SinkConfig{QueueSize: 1}, &logrus.JSONFormatter{}, w)
+	s.Fire(newKafkaEntry("first", nil))
+	<-w.received
+	s.Fire(newKafkaEntry("queued", nil))
+	s.Fire(newKafkaEntry("dropped", nil))
+	close(w.release)
+	s.Close()
+	s.Fire(newKafkaEntry("after close", nil))
+	if stats := s.(StatsSink).Stats(); stats != (SinkStats{Written: 2, Dropped: 2}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	s = NewWriterSink(&SinkConfig{}, &logrus.JSONFormatter{}, failWriter{})
+	s.Fire(newKafkaEntry("lost", nil))
+	s.Close()
+	if stats := s.(StatsSink).Stats(); stats != (SinkStats{Failed: 1}) {
+		t.Errorf("unexpected stats %+v with a failing writer", stats)
+	}
+}
+
+func TestSinkCloneOwnership(t *testing.T) {
+	var mp *mocks.AsyncProducer
+	RegisterSink("test_kafka", func(c *SinkConfig, f logrus.Formatter) (Sink, error) {
+		kc, err := c.Kafka.saramaConfig()
+		if err != nil {
+			return nil, err
+		}
+		mp = mocks.NewAsyncProducer(t, kc)
+		hook, err := newKafkaLogrusHook(logrus.AllLevels, KafkaFormatter(f, c.Kafka), c.Kafka, mp)
+		if err != nil {
+			return nil, err
+		}
+		return &kafkaSink{hook}, nil
+	})
+	name := filepath.Join(t.TempDir(), "app.log")
+	l := NewLoggerWithConfig(&Config{Level: "info", Sinks: []*SinkConfig{
+		{Type: SinkTypeFile, File: &FileConfig{FileName: name}},
+		{Type: "test_kafka", Kafka: &KafkaConfig{Topic: "logs"}},
+	}}, 1)
+	var mu sync.Mutex
+	var produced []string
+	for i := 0; i < 2; i++ {
+		mp.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			value, _ := msg.Value.Encode()
+			mu.Lock()
+			produced = append(produced, string(value))
+			mu.Unlock()
+			return nil
+		})
+	}
+	l.SetTraceId("root-trace")
+	clone := l.Clone(2)
+	clone.SetTraceId("clone-trace")
+	clone.Info("from clone")
+	if err := clone.Close(); err != nil { //clone不拥有FileWriter与producer，关闭后原Logger仍可写入
+		t.Fatal(err)
+	}
+	l.Info("from root")
+	waitFor(t, time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(produced) == 2
+	})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "traceid=clone-trace") || !strings.Contains(lines[1], "traceid=root-trace") {
+		t.Errorf("file sink got %q", b)
+	}
+	if !strings.Contains(produced[0], "clone-trace") || !strings.Contains(produced[1], "root-trace") {
+		t.Errorf("kafka sink got %q", produced)
+	}
+	stats := l.SinkStats()
+	if len(stats) != 2 || stats[0] != (SinkStats{Type: SinkTypeFile, Written: 2}) || stats[1] != (SinkStats{Type: "test_kafka"}) {
+		t.Errorf("unexpected sink stats %+v", stats)
+	}
+	if fs := l.FileStats(); fs != (FileWriterStats{Written: 2}) {
+		t.Errorf("unexpected file stats %+v", fs)
+	}
+}
@@ -0,0 +1,95 @@
+package hlog
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TagInfo describes a success/failure tag pair and the levels CallRecorder logs them at.
+// A zero level (logrus.PanicLevel) stands for the default, InfoLevel on success and
+// ErrorLevel on failure.
+type TagInfo struct {
+	Success      string
+	Failure      string
+	SuccessLevel logrus.Level
+	FailureLevel logrus.Level
+}
+
+type tagRegistry struct {
+	mu        sync.RWMutex
+	bySuccess map[string]TagInfo
+	byFailure map[string]TagInfo
+}
+
+var registeredTags = &tagRegistry{
+	bySuccess: map[string]TagInfo{},
+	byFailure: map[string]TagInfo{},
+}
+
+func init() {
+	for success, failure := range TagDescSuccMapErr {
+		RegisterTagPair(success, failure)
+	}
+}
+
+// RegisterTagPair registers a success tag and its failure counterpart with default levels
+func RegisterTagPair(success, failure string) error {
+	return RegisterTag(TagInfo{Success: success, Failure: failure})
+}
+
+// RegisterTag registers or replaces a tag pair, it is safe for concurrent use
+func RegisterTag(info TagInfo) error {
+	if len(info.Success) == 0 || len(info.Failure) == 0 {
+		return errors.New("empty success or failure tag")
+	}
+	if info.Success == info.Failure {
+		return errors.New("success and failure tag must differ")
+	}
+	if info.SuccessLevel == logrus.PanicLevel {
+		info.SuccessLevel = logrus.InfoLevel
+	}
+	if info.FailureLevel == logrus.PanicLevel {
+		info.FailureLevel = logrus.ErrorLevel
+	}
+	registeredTags.mu.Lock()
+	defer registeredTags.mu.Unlock()
+	if old, ok := registeredTags.bySuccess[info.Success]; ok {
+		delete(registeredTags.byFailure, old.Failure)
+	}
+	registeredTags.bySuccess[info.Success] = info
+	registeredTags.byFailure[info.Failure] = info
+	return nil
+}
+
+// LookupTag returns the pair tag belongs to, as either its success or its failure tag
+func LookupTag(tag string) (TagInfo, bool) {
+	registeredTags.mu.RLock()
+	info, ok := registeredTags.bySuccess[tag]
+	if !ok {
+		info, ok = registeredTags.byFailure[tag]
+	}
+	registeredTags.mu.RUnlock()
+	return info, ok
+}
+
+// FailureTag returns the failure counterpart of the success tag
+func FailureTag(success string) (string, bool) {
+	if info, ok := LookupTag(success); ok && info.Success == success {
+		return info.Failure, true
+	}
+	return "", false
+}
+
+// derivedFailureTag 返回未注册的success tag对应的failure tag，_success后缀换成_failure，没有该后缀时直接追加
+func derivedFailureTag(success string) string {
+	return strings.TrimSuffix(success, "_success") + "_failure"
+}
+
+// IsFailureTag reports whether tag is the failure tag of a registered pair
+func IsFailureTag(tag string) bool {
+	info, ok := LookupTag(tag)
+	return ok && info.Failure == tag
+}
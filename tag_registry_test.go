@@ -0,0 +1,53 @@
+package hlog
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRegisterTagConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				success := fmt.Sprintf("_test_%d_%d_success", i, n)
+				failure := fmt.Sprintf("_test_%d_%d_failure", i, n)
+				if err := RegisterTagPair(success, failure); err != nil {
+					t.Error(err)
+					return
+				}
+				if got, ok := FailureTag(success); !ok || got != failure {
+					t.Errorf("FailureTag(%s) = %s, %v", success, got, ok)
+				}
+				if !IsFailureTag(failure) || IsFailureTag(success) {
+					t.Errorf("IsFailureTag mismatch for %s", success)
+				}
+				LookupTag(LogTagMysqlOk)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRegisterTag(t *testing.T) {
+	if err := RegisterTag(TagInfo{Success: "_com_mongo_success", Failure: "_com_mongo_failure", FailureLevel: logrus.WarnLevel}); err != nil {
+		t.Fatal(err)
+	}
+	info, ok := LookupTag("_com_mongo_failure")
+	if !ok || info.Success != "_com_mongo_success" || info.SuccessLevel != logrus.InfoLevel || info.FailureLevel != logrus.WarnLevel {
+		t.Errorf("unexpected tag info %+v, %v", info, ok)
+	}
+	for _, info := range []TagInfo{{Success: "a"}, {Success: "a", Failure: "a"}} {
+		if err := RegisterTag(info); err == nil {
+			t.Errorf("RegisterTag(%+v) succeeded", info)
+		}
+	}
+	if _, ok := LookupTag(LogTagRpcErr); !ok {
+		t.Error("built-in pairs are not registered")
+	}
+}
@@ -0,0 +1,154 @@
+package hlog
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	w3cTraceVersion      = "00"
+	w3cDefaultTraceFlags = "01"
+	w3cTraceIdLen        = 32
+	w3cSpanIdLen         = 16
+)
+
+func validTracePropagation(p string) bool {
+	switch p {
+	case TracePropagationLegacy, TracePropagationW3C, TracePropagationBoth:
+		return true
+	}
+	return false
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isValidW3CId(id string, length int) bool {
+	return len(id) == length && isLowerHex(id) && strings.Trim(id, "0") != ""
+}
+
+// parseTraceParent 解析形如00-<trace-id>-<parent-id>-<flags>的traceparent
+func parseTraceParent(v string) (traceId, parentId, flags string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || !isLowerHex(parts[0]) || parts[0] == "ff" {
+		return "", "", "", false
+	}
+	if parts[0] == w3cTraceVersion && len(parts) != 4 {
+		return "", "", "", false
+	}
+	if !isValidW3CId(parts[1], w3cTraceIdLen) || !isValidW3CId(parts[2], w3cSpanIdLen) ||
+		len(parts[3]) != 2 || !isLowerHex(parts[3]) {
+		return "", "", "", false
+	}
+	return parts[1], parts[2], parts[3], true
+}
+
+// formatTraceParent 生成向下游传递的traceparent，本服务的span作为下游的parent
+func formatTraceParent(t *Trace) string {
+	spanId := t.SpanId
+	if !isValidW3CId(spanId, w3cSpanIdLen) {
+		spanId = newSpanId()
+	}
+	flags := t.TraceFlags
+	if len(flags) != 2 || !isLowerHex(flags) {
+		flags = w3cDefaultTraceFlags
+	}
+	return fmt.Sprintf("%s-%s-%s-%s", w3cTraceVersion, w3cTraceId(t.TraceId), spanId, flags)
+}
+
+// w3cTraceId 将traceId转换为合法的W3C trace-id，旧格式的traceId通常已满足要求，不满足时由其哈希得到
+func w3cTraceId(traceId string) string {
+	if id := strings.ToLower(traceId); isValidW3CId(id, w3cTraceIdLen) {
+		return id
+	}
+	if len(traceId) == 0 {
+		return newW3CTraceId()
+	}
+	sum := sha256.Sum256([]byte(traceId))
+	return hex.EncodeToString(sum[:w3cTraceIdLen/2])
+}
+
+func newW3CTraceId() string {
+	if id := calculateTraceId(getIp()); isValidW3CId(id, w3cTraceIdLen) {
+		return id
+	}
+	return randomHex(w3cTraceIdLen / 2)
+}
+
+func newSpanId() string {
+	return randomHex(w3cSpanIdLen / 2)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil || strings.Trim(hex.EncodeToString(b), "0") == "" {
+		b[n-1] = 1
+	}
+	return hex.EncodeToString(b)
+}
+
+// newTrace 生成一条新的trace，w3c模式下同时生成本服务的span
+func (f *DefaultLogFormatter) newTrace() *Trace {
+	t := &Trace{}
+	if f.TracePropagation == TracePropagationLegacy {
+		t.TraceId = calculateTraceId(getIp())
+	} else {
+		t.TraceId = newW3CTraceId()
+		t.SpanId = newSpanId()
+		t.TraceFlags = w3cDefaultTraceFlags
+	}
+	return t
+}
+
+// extractTrace 按照传播方式从carrier中解析trace，both模式下优先使用traceparent，没有时回退到旧的header
+func (f *DefaultLogFormatter) extractTrace(h TraceCarrier) (*Trace, bool) {
+	if f.TracePropagation != TracePropagationLegacy {
+		if traceId, parentId, flags, ok := parseTraceParent(h.Get(TraceParentHeader)); ok {
+			if f.TracePropagation == TracePropagationBoth {
+				//上游同时发送了两种header时，保留旧格式的traceId，以便与只认旧header的服务对齐
+				if legacyId := h.Get(f.TraceHeader); len(legacyId) > 0 && w3cTraceId(legacyId) == traceId {
+					traceId = legacyId
+				}
+			}
+			return &Trace{
+				TraceId:      traceId,
+				SpanId:       newSpanId(),
+				ParentSpanId: parentId,
+				TraceFlags:   flags,
+				TraceState:   h.Get(TraceStateHeader),
+			}, true
+		}
+	}
+	if f.TracePropagation != TracePropagationW3C {
+		if traceId := h.Get(f.TraceHeader); len(traceId) > 0 {
+			t := &Trace{TraceId: traceId}
+			if f.TracePropagation == TracePropagationBoth {
+				t.SpanId = newSpanId()
+			}
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// injectTrace 按照传播方式将trace写入carrier
+func (f *DefaultLogFormatter) injectTrace(h TraceCarrier, t *Trace) {
+	if f.TracePropagation != TracePropagationW3C {
+		h.Set(f.TraceHeader, t.TraceId)
+	}
+	if f.TracePropagation != TracePropagationLegacy {
+		h.Set(TraceParentHeader, formatTraceParent(t))
+		if len(t.TraceState) > 0 {
+			h.Set(TraceStateHeader, t.TraceState)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package hlog
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testTraceId  = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testParentId = "00f067aa0ba902b7"
+)
+
+func newPropagationFormatter(propagation string) *DefaultLogFormatter {
+	return NewDefaultLogFormatter(&Config{TracePropagation: propagation}, logrus.Fields{}, 1).(*DefaultLogFormatter)
+}
+
+func TestParseTraceParent(t *testing.T) {
+	valid := fmt.Sprintf("00-%s-%s-01", testTraceId, testParentId)
+	for _, c := range []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"valid", valid, true},
+		{"surrounding spaces", " " + valid + " ", true},
+		{"empty", "", false},
+		{"uppercase trace id", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"uppercase version", fmt.Sprintf("0A-%s-%s-01", testTraceId, testParentId), false},
+		{"all-zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"all-zero parent id", fmt.Sprintf("00-%s-0000000000000000-01", testTraceId), false},
+		{"version ff", fmt.Sprintf("ff-%s-%s-01", testTraceId, testParentId), false},
+		{"extra field with version 00", valid + "-extra", false},
+		{"extra fields with a future version", fmt.Sprintf("cc-%s-%s-01-extra-more", testTraceId, testParentId), true},
+		{"missing flags with a future version", fmt.Sprintf("cc-%s-%s", testTraceId, testParentId), false},
+		{"short trace id", fmt.Sprintf("00-%s-%s-01", testTraceId[1:], testParentId), false},
+		{"bad flags", fmt.Sprintf("00-%s-%s-1", testTraceId, testParentId), false},
+	} {
+		traceId, parentId, flags, ok := parseTraceParent(c.value)
+		if ok != c.ok {
+			t.Errorf("%s: ok %v, want %v", c.name, ok, c.ok)
+		} else if ok && (traceId != testTraceId || parentId != testParentId || flags != "01") {
+			t.Errorf("%s: parsed %s %s %s", c.name, traceId, parentId, flags)
+		}
+	}
+}
+
+func TestExtractTrace(t *testing.T) {
+	traceParent := fmt.Sprintf("00-%s-%s-01", testTraceId, testParentId)
+	hashedParent := fmt.Sprintf("00-%s-%s-01", w3cTraceId("legacy-id"), testParentId)
+	for _, c := range []struct {
+		name        string
+		propagation string
+		headers     MapCarrier
+		traceId     string //为空表示解析不到trace
+		parentId    string
+		traceState  string
+	}{
+		{"legacy reads the legacy header", TracePropagationLegacy,
+			MapCarrier{DefaultTraceHeader: "legacy-id", TraceParentHeader: traceParent}, "legacy-id", "", ""},
+		{"legacy ignores traceparent", TracePropagationLegacy,
+			MapCarrier{TraceParentHeader: traceParent}, "", "", ""},
+		{"w3c reads traceparent and tracestate", TracePropagationW3C,
+			MapCarrier{TraceParentHeader: traceParent, TraceStateHeader: "congo=t61rcWkgMzE"}, testTraceId, testParentId, "congo=t61rcWkgMzE"},
+		{"w3c ignores the legacy header", TracePropagationW3C,
+			MapCarrier{DefaultTraceHeader: "legacy-id"}, "", "", ""},
+		{"w3c rejects an invalid traceparent", TracePropagationW3C,
+			MapCarrier{TraceParentHeader: "00-zz-00f067aa0ba902b7-01"}, "", "", ""},
+		{"both prefers traceparent", TracePropagationBoth,
+			MapCarrier{DefaultTraceHeader: "other-id", TraceParentHeader: traceParent}, testTraceId, testParentId, ""},
+		{"both keeps a legacy id matching traceparent", TracePropagationBoth,
+			MapCarrier{DefaultTraceHeader: "legacy-id", TraceParentHeader: hashedParent, TraceStateHeader: "a=b"}, "legacy-id", testParentId, "a=b"},
+		{"both keeps an uppercase legacy id", TracePropagationBoth,
+			MapCarrier{DefaultTraceHeader: "4BF92F3577B34DA6A3CE929D0E0E4736", TraceParentHeader: traceParent}, "4BF92F3577B34DA6A3CE929D0E0E4736", testParentId, ""},
+		{"both falls back to the legacy header", TracePropagationBoth,
+			MapCarrier{DefaultTraceHeader: "legacy-id", TraceParentHeader: "ff-bad"}, "legacy-id", "", ""},
+		{"no headers", TracePropagationBoth, MapCarrier{}, "", "", ""},
+	} {
+		trace, ok := newPropagationFormatter(c.propagation).extractTrace(c.headers)
+		if !ok {
+			if len(c.traceId) > 0 {
+				t.Errorf("%s: no trace extracted", c.name)
+			}
+			continue
+		}
+		if trace.TraceId != c.traceId || trace.ParentSpanId != c.parentId || trace.TraceState != c.traceState {
+			t.Errorf("%s: got %+v", c.name, trace)
+		}
+		if c.propagation != TracePropagationLegacy && !isValidW3CId(trace.SpanId, w3cSpanIdLen) {
+			t.Errorf("%s: no span id for this service in %+v", c.name, trace)
+		}
+	}
+}
+
+func TestInjectTrace(t *testing.T) {
+	trace := &Trace{TraceId: "legacy-id", SpanId: testParentId, TraceFlags: "01", TraceState: "congo=t61rcWkgMzE"}
+	traceParent := fmt.Sprintf("00-%s-%s-01", w3cTraceId("legacy-id"), testParentId)
+	for _, c := range []struct {
+		propagation string
+		want        MapCarrier
+	}{
+		{TracePropagationLegacy, MapCarrier{DefaultTraceHeader: "legacy-id"}},
+		{TracePropagationW3C, MapCarrier{TraceParentHeader: traceParent, TraceStateHeader: "congo=t61rcWkgMzE"}},
+		{TracePropagationBoth, MapCarrier{DefaultTraceHeader: "legacy-id", TraceParentHeader: traceParent, TraceStateHeader: "congo=t61rcWkgMzE"}},
+	} {
+		h := MapCarrier{}
+		newPropagationFormatter(c.propagation).injectTrace(h, trace)
+		if fmt.Sprint(h) != fmt.Sprint(c.want) {
+			t.Errorf("%s: injected %v, want %v", c.propagation, h, c.want)
+		}
+	}
+
+	//both模式下游解析出的traceId与上游的旧格式traceId一致，tracestate原样传递
+	h := MapCarrier{}
+	f := newPropagationFormatter(TracePropagationBoth)
+	f.injectTrace(h, trace)
+	got, ok := f.extractTrace(h)
+	if !ok || got.TraceId != "legacy-id" || got.ParentSpanId != testParentId || got.TraceState != trace.TraceState {
+		t.Errorf("round trip got %+v", got)
+	}
+}
@@ -11,13 +11,13 @@ type WaitGroupWrapper struct {
 
 func (w *WaitGroupWrapper) Wrap(cb func()) {
 	w.Add(1)
-	defer w.Done()
-	defer func() {
-		if err := recover(); err != nil {
-			log.Printf("go routine run error: %+v", err)
-		}
-	}()
 	go func() {
+		defer w.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("go routine run error: %+v", err)
+			}
+		}()
 		cb()
 	}()
 }
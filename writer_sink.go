@@ -0,0 +1,140 @@
+package hlog
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultWriterSinkQueueSize = 10000
+
+// writerQueue 由一个writer sink及其Clone出的sink共享
+type writerQueue struct {
+	w            io.Writer
+	queue        chan []byte
+	overflow     string
+	blockTimeout time.Duration
+	written      atomic.Uint64
+	dropped      atomic.Uint64 //因队列满或已关闭而丢弃的条数
+	failed       atomic.Uint64 //写入w失败的条数
+	closeOnce    sync.Once
+	closeChan    chan struct{}
+	doneChan     chan struct{}
+}
+
+// writerSink 在后台协程中将日志写入w，供自定义sink使用
+type writerSink struct {
+	*writerQueue
+	formatter logrus.Formatter
+}
+
+// NewWriterSink creates a sink that formats entries with f and writes them to w from a
+// background goroutine, buffered by c.QueueSize and c.Overflow (drop_newest, drop_oldest
+// or block with c.BlockTimeout). w is closed with the sink if it is an io.Closer.
+// It is meant for custom sink factories, e.g. a syslog or UDP writer. The returned sink
+// implements StatsSink, so its written, dropped and failed entries show up in Logger.SinkStats.
+func NewWriterSink(c *SinkConfig, f logrus.Formatter, w io.Writer) Sink {
+	q := &writerQueue{
+		w:            w,
+		overflow:     c.Overflow,
+		blockTimeout: time.Duration(c.BlockTimeout) * time.Millisecond,
+		closeChan:    make(chan struct{}),
+		doneChan:     make(chan struct{}),
+	}
+	queueSize := c.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultWriterSinkQueueSize
+	}
+	q.queue = make(chan []byte, queueSize)
+	switch q.overflow {
+	case "":
+		q.overflow = OverflowDropNewest
+	case OverflowDropNewest, OverflowDropOldest, OverflowBlock:
+	default:
+		fmt.Printf("invalid %s sink overflow policy %s, use %s instead\n", c.Type, q.overflow, OverflowDropNewest)
+		q.overflow = OverflowDropNewest
+	}
+	go q.run()
+	return &writerSink{q, f}
+}
+
+func (s *writerSink) Fire(entry *logrus.Entry) error {
+	b, err := s.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	if len(b) > 0 {
+		s.enqueue(b)
+	}
+	return nil
+}
+
+func (s *writerSink) Clone(f logrus.Formatter) Sink {
+	return &writerSink{s.writerQueue, f}
+}
+
+// enqueue 将日志放入队列，队列满时按照配置的策略处理
+func (q *writerQueue) enqueue(p []byte) {
+	select {
+	case <-q.closeChan:
+		q.dropped.Add(1)
+		return
+	default:
+	}
+	if !enqueueOverflow(q.queue, p, q.overflow, q.blockTimeout, q.closeChan, &q.dropped) {
+		q.dropped.Add(1)
+	}
+}
+
+func (q *writerQueue) run() {
+	defer close(q.doneChan)
+	for {
+		select {
+		case p := <-q.queue:
+			q.write(p)
+		case <-q.closeChan:
+			for {
+				select {
+				case p := <-q.queue:
+					q.write(p)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (q *writerQueue) write(p []byte) {
+	if _, err := q.w.Write(p); err != nil {
+		q.failed.Add(1)
+		fmt.Printf("sink write err: %v\n", err)
+		return
+	}
+	q.written.Add(1)
+}
+
+// Stats 返回队列的统计，由sink及其Clone出的sink共享
+func (q *writerQueue) Stats() SinkStats {
+	return SinkStats{
+		Written: q.written.Load(),
+		Dropped: q.dropped.Load(),
+		Failed:  q.failed.Load(),
+	}
+}
+
+// Close 等待队列中的日志写完后关闭w
+func (q *writerQueue) Close() (err error) {
+	q.closeOnce.Do(func() {
+		close(q.closeChan)
+		<-q.doneChan
+		if c, ok := q.w.(io.Closer); ok {
+			err = c.Close()
+		}
+	})
+	return
+}